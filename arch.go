@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// universalArchs はどのホストにもインストールできるアーキテクチャ名
+var universalArchs = []string{"any", "all", "noarch"}
+
+// goarchToArch はGOARCHをPKGBUILDで使われるアーキテクチャ名に対応付ける
+var goarchToArch = map[string]string{
+	"amd64":   "x86_64",
+	"386":     "i686",
+	"arm64":   "aarch64",
+	"arm":     "armv7h",
+	"riscv64": "riscv64",
+	"ppc64le": "powerpc64le",
+	"s390x":   "s390x",
+}
+
+func isUniversalArch(arch string) bool {
+	return slices.Contains(universalArchs, arch)
+}
+
+// hostArch はこのマシンのアーキテクチャ名を返す
+func hostArch() string {
+	if arch, ok := goarchToArch[runtime.GOARCH]; ok {
+		return arch
+	}
+	return runtime.GOARCH
+}
+
+// selectArch はPKGBUILDのarch配列からhost向けのアーキテクチャを選ぶ。
+// arch配列が空の場合は制限なしとみなす
+func selectArch(archs []string, host string) (string, error) {
+	if len(archs) == 0 {
+		return host, nil
+	}
+	for _, arch := range archs {
+		if isUniversalArch(arch) {
+			return arch, nil
+		}
+	}
+	if slices.Contains(archs, host) {
+		return host, nil
+	}
+	return "", fmt.Errorf("アーキテクチャ %s には対応していません（対応: %s）", host, strings.Join(archs, ", "))
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestSelectArch(t *testing.T) {
+	tests := []struct {
+		archs   []string
+		host    string
+		want    string
+		wantErr bool
+	}{
+		// noarchのパッケージはどのホストにも入る
+		{[]string{"any"}, "x86_64", "any", false},
+		{[]string{"any"}, "aarch64", "any", false},
+		{[]string{"all"}, "riscv64", "all", false},
+		{[]string{"noarch"}, "i686", "noarch", false},
+		{[]string{"x86_64", "any"}, "aarch64", "any", false},
+		// arch配列がなければ制限なし
+		{nil, "aarch64", "aarch64", false},
+		{[]string{"x86_64", "aarch64"}, "aarch64", "aarch64", false},
+		{[]string{"x86_64"}, "aarch64", "", true},
+	}
+	for _, tt := range tests {
+		got, err := selectArch(tt.archs, tt.host)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("selectArch(%q, %q) = %q, %v; want %q, error %t", tt.archs, tt.host, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestIsUniversalArch(t *testing.T) {
+	for _, arch := range []string{"any", "all", "noarch"} {
+		if !isUniversalArch(arch) {
+			t.Errorf("isUniversalArch(%q) = false, want true", arch)
+		}
+	}
+	for _, arch := range []string{"x86_64", "aarch64", "", "ANY"} {
+		if isUniversalArch(arch) {
+			t.Errorf("isUniversalArch(%q) = true, want false", arch)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// installBatch は1回のInstallAllで行った変更の記録。失敗したときにrollbackで元に戻す
+type installBatch struct {
+	buildDir  string
+	backupDir string // 置き換える前のファイルの退避先。最初に退避するときに作る
+	entries   []batchEntry
+}
+
+// batchEntry はパッケージ1つ分の変更。previousがnilなら新規インストール
+type batchEntry struct {
+	pkg      *Package // Installが登録するもの。Filesはインストール後に設定される
+	previous *Package // 置き換える前の記録（ファイルを含む）
+}
+
+// InstallAll はpathsを順にインストールする。1つでも失敗したら、この呼び出しで
+// インストール・更新したパッケージを逆順に元に戻してから、そのエラーを返す。
+// stopを受信したら、それまでのパッケージはそのままにして残りを処理せずに止める。
+// ロールバックを終えるまで他のfrpmが割り込めないよう、ロックは全体を通して保持する
+func (pm *PackageManager) InstallAll(paths []string, opts InstallOptions, stop <-chan os.Signal) error {
+	if opts.DryRun {
+		return pm.printPlan(paths, opts)
+	}
+
+	unlock, err := pm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	batch := &installBatch{buildDir: pm.buildDir}
+	defer batch.cleanup()
+	opts.batch = batch
+
+	for i, path := range paths {
+		select {
+		case <-stop:
+			return fmt.Errorf("SIGTERMを受信したため終了します（%d 個中 %d 個をインストール済み、未処理: %s）",
+				len(paths), i, strings.Join(paths[i:], " "))
+		default:
+		}
+		if err := pm.install(path, opts); err != nil {
+			if len(batch.entries) == 0 {
+				return err
+			}
+			fmt.Printf("\n==> %s のインストールに失敗したため、このコマンドでの変更を元に戻します\n", path)
+			if rbErr := pm.rollback(batch); rbErr != nil {
+				return fmt.Errorf("ロールバックに失敗: %v", rbErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// record はpkgのファイルを置き換える直前に呼び、previousのファイルを退避しておく
+func (b *installBatch) record(pm *PackageManager, pkg, previous *Package) error {
+	if previous != nil && len(previous.Files) > 0 && b.backupDir == "" {
+		dir, err := os.MkdirTemp(b.buildDir, ".rollback-*")
+		if err != nil {
+			return fmt.Errorf("退避用ディレクトリの作成に失敗: %v", permissionError(err))
+		}
+		b.backupDir = dir
+	}
+	if previous != nil {
+		for _, f := range previous.Files {
+			src := filepath.Join(pm.installRoot, f.Path)
+			if _, err := os.Stat(src); os.IsNotExist(err) {
+				continue
+			}
+			if err := copyFile(src, filepath.Join(b.backupDir, pkg.Name, f.Path)); err != nil {
+				return fmt.Errorf("%s の退避に失敗: %v", f.Path, err)
+			}
+		}
+	}
+	b.entries = append(b.entries, batchEntry{pkg: pkg, previous: previous})
+	return nil
+}
+
+// cleanup は退避したファイルを削除する
+func (b *installBatch) cleanup() {
+	if b.backupDir != "" {
+		os.RemoveAll(b.backupDir)
+	}
+}
+
+// rollback はbatchの変更を新しいものから順に元に戻す。新規インストールしたパッケージは
+// ファイルと記録を削除し、更新したパッケージは退避したファイルと以前の記録を戻す。
+// installスクリプトの処理や作成したディレクトリは元に戻さない。
+// 呼び出し側がロックを取得している必要がある
+func (pm *PackageManager) rollback(batch *installBatch) error {
+	for i := len(batch.entries) - 1; i >= 0; i-- {
+		e := batch.entries[i]
+		fmt.Printf("==> %s を元に戻しています...\n", e.pkg.Name)
+
+		for _, f := range e.pkg.Files {
+			if err := os.Remove(filepath.Join(pm.installRoot, f.Path)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if e.previous == nil {
+			if err := pm.unregisterPackage(e.pkg.Name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, f := range e.previous.Files {
+			backup := filepath.Join(batch.backupDir, e.pkg.Name, f.Path)
+			if _, err := os.Stat(backup); os.IsNotExist(err) {
+				continue
+			}
+			dst := filepath.Join(pm.installRoot, f.Path)
+			if err := copyFile(backup, dst); err != nil {
+				return err
+			}
+			// verifyがハッシュ計算を省略できるよう更新日時も記録に合わせる
+			mtime := time.Unix(0, f.ModTime)
+			if err := os.Chtimes(dst, mtime, mtime); err != nil {
+				return err
+			}
+		}
+		if err := pm.registerPackage(e.previous); err != nil {
+			return err
+		}
+		_, err := pm.db.Exec(`UPDATE packages SET installed_at = ?, held = ? WHERE name = ?`,
+			e.previous.InstalledAt, e.previous.Held, e.pkg.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unregisterPackage はパッケージの記録をDBから全て削除する
+func (pm *PackageManager) unregisterPackage(pkgName string) error {
+	tx, err := pm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"sources", "dependencies", "provides", "files"} {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE package_name = ?", table), pkgName); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM packages WHERE name = ?`, pkgName); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestPackageManager(t *testing.T) *PackageManager {
+	t.Helper()
+	dir := t.TempDir()
+	pm, err := NewPackageManager(filepath.Join(dir, "db", "packages.db"), filepath.Join(dir, "build"), filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pm.Close() })
+	return pm
+}
+
+// writePKGBUILD はpackage()でpathにcontentを書くPKGBUILDを作る。contentが空ならpackage()は失敗する
+func writePKGBUILD(t *testing.T, name, version, path, content string) string {
+	t.Helper()
+	body := "  exit 1"
+	if content != "" {
+		body = `  mkdir -p "$pkgdir/` + filepath.Dir(path) + `"` + "\n" +
+			`  echo ` + content + ` > "$pkgdir/` + path + `"`
+	}
+	pkgbuild := filepath.Join(t.TempDir(), "PKGBUILD")
+	text := "pkgname=" + name + "\npkgver=" + version + "\npkgrel=1\narch=('any')\n" +
+		"package() {\n" + body + "\n}\n"
+	if err := os.WriteFile(pkgbuild, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return pkgbuild
+}
+
+func TestInstallAllRollsBackOnFailure(t *testing.T) {
+	pm := newTestPackageManager(t)
+
+	if err := pm.Install(writePKGBUILD(t, "base", "1.0", "share/base/data", "v1"), InstallOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{
+		writePKGBUILD(t, "base", "2.0", "share/base/data", "v2"),
+		writePKGBUILD(t, "fresh", "1.0", "share/fresh/data", "fresh"),
+		writePKGBUILD(t, "broken", "1.0", "", ""),
+	}
+	if err := pm.InstallAll(paths, InstallOptions{}, nil); err == nil {
+		t.Fatal("InstallAll succeeded, want the error from broken")
+	}
+
+	base, ok, err := pm.GetInstalled("base")
+	if err != nil || !ok {
+		t.Fatalf("base is not installed after rollback (err=%v)", err)
+	}
+	if v := base.FullVersion(); v != "1.0-1" {
+		t.Errorf("base version = %s, want 1.0-1", v)
+	}
+	data, err := os.ReadFile(filepath.Join(pm.installRoot, "share/base/data"))
+	if err != nil || string(data) != "v1\n" {
+		t.Errorf("share/base/data = %q (err=%v), want v1", data, err)
+	}
+
+	for _, name := range []string{"fresh", "broken"} {
+		if _, ok, err := pm.GetInstalled(name); err != nil || ok {
+			t.Errorf("%s is installed after rollback (err=%v)", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(pm.installRoot, "share/fresh/data")); !os.IsNotExist(err) {
+		t.Errorf("share/fresh/data still exists (err=%v)", err)
+	}
+	if owner, err := pm.WhichPackageOwns(filepath.Join(pm.installRoot, "share/fresh/data")); err == nil {
+		t.Errorf("share/fresh/data is still owned by %s", owner)
+	}
+}
+
+func TestInstallAllDryRunChangesNothing(t *testing.T) {
+	pm := newTestPackageManager(t)
+
+	paths := []string{writePKGBUILD(t, "fresh", "1.0", "share/fresh/data", "fresh")}
+	if err := pm.InstallAll(paths, InstallOptions{DryRun: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := pm.GetInstalled("fresh"); err != nil || ok {
+		t.Errorf("fresh is installed after a dry run (err=%v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(pm.installRoot, "share/fresh/data")); !os.IsNotExist(err) {
+		t.Errorf("share/fresh/data exists after a dry run (err=%v)", err)
+	}
+}
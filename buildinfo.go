@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// リリースビルドでは -ldflags "-X main.buildVersion=v1.2.3 -X main.buildCommit=abcdef" で埋め込む。
+// 空の場合はGoが記録したビルド情報から補う
+var (
+	buildVersion string
+	buildCommit  string
+)
+
+// printVersion はツールのバージョン、コミット、Goのバージョンを表示する。DBには触れない
+func printVersion(w io.Writer) {
+	version, commit, modified := buildVersion, buildCommit, false
+	goVersion := runtime.Version()
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		goVersion = info.GoVersion
+		if version == "" {
+			version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if commit == "" {
+					commit = s.Value
+				}
+			case "vcs.modified":
+				modified = buildCommit == "" && s.Value == "true"
+			}
+		}
+	}
+
+	if version == "" {
+		version = "(devel)"
+	}
+	if commit == "" {
+		commit = "不明"
+	} else if modified {
+		commit += "（未コミットの変更あり）"
+	}
+
+	fmt.Fprintf(w, "frpm %s\n", version)
+	fmt.Fprintf(w, "コミット: %s\n", commit)
+	fmt.Fprintf(w, "Go: %s\n", goVersion)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksumAlgos はPKGBUILDの *sums 配列のうち対応しているもの。強いものから順に探す
+var checksumAlgos = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha512", sha512.New},
+	{"sha256", sha256.New},
+	{"sha1", sha1.New},
+	{"md5", md5.New},
+}
+
+// parseChecksums はPKGBUILDから最も強いチェックサム配列を取り出す
+func parseChecksums(text string) (string, []string) {
+	for _, algo := range checksumAlgos {
+		if sums := extractArrayVar(text, algo.name+"sums"); len(sums) > 0 {
+			return algo.name, sums
+		}
+	}
+	return "", nil
+}
+
+// expandSource は "foo.tar.gz{,.sig}" のような波括弧をbashと同じように展開する
+func expandSource(src string) []string {
+	open := strings.Index(src, "{")
+	end := strings.Index(src, "}")
+	if open < 0 || end < open {
+		return []string{src}
+	}
+
+	result := []string{}
+	for _, alt := range strings.Split(src[open+1:end], ",") {
+		result = append(result, src[:open]+alt+src[end+1:])
+	}
+	return result
+}
+
+// sourceFilename はソースの記述からビルドディレクトリ内のファイル名を求める
+func sourceFilename(src string) string {
+	if name, _, ok := strings.Cut(src, "::"); ok {
+		return name
+	}
+	filename := filepath.Base(src)
+	if idx := strings.Index(filename, "?"); idx > 0 {
+		filename = filename[:idx]
+	}
+	return filename
+}
+
+// sourceURL はソースの記述から取得先を返す。"name::url" ならurlの部分
+func sourceURL(src string) string {
+	if _, url, ok := strings.Cut(src, "::"); ok {
+		return url
+	}
+	return src
+}
+
+func verifyChecksum(path, algo, expected string) error {
+	var newHash func() hash.Hash
+	for _, a := range checksumAlgos {
+		if a.name == algo {
+			newHash = a.new
+		}
+	}
+	if newHash == nil {
+		return fmt.Errorf("未対応のチェックサム: %s", algo)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("チェックサムが一致しません（期待値: %s, 実際: %s）", expected, actual)
+	}
+	return nil
+}
+
+// verifySources はソースをチェックサムで検証し、検証できなかったソース（SKIPや
+// チェックサムなし）の数を返す。一致しないものがあればエラー
+func verifySources(pkg *Package, dir string) (int, error) {
+	sources := []string{}
+	for _, src := range pkg.Source {
+		sources = append(sources, expandSource(src)...)
+	}
+
+	if pkg.SumAlgo == "" {
+		return len(sources), nil
+	}
+	if len(pkg.Checksums) != len(sources) {
+		return 0, fmt.Errorf("ソース（%d個）と%ssums（%d個）の数が一致しません",
+			len(sources), pkg.SumAlgo, len(pkg.Checksums))
+	}
+
+	unverified := 0
+	for i, src := range sources {
+		name := sourceFilename(src)
+		if strings.EqualFold(pkg.Checksums[i], "SKIP") {
+			fmt.Printf("  -> %s ... スキップ\n", name)
+			unverified++
+			continue
+		}
+		if err := verifyChecksum(filepath.Join(dir, name), pkg.SumAlgo, pkg.Checksums[i]); err != nil {
+			return 0, fmt.Errorf("%s: %v", name, err)
+		}
+		fmt.Printf("  -> %s ... OK\n", name)
+	}
+
+	return unverified, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFetchedSourcesMatchVerifiedNames は "name::url" と {,.sig} のソースが
+// verifySourcesの探す名前で保存されることを確かめる
+func TestFetchedSourcesMatchVerifiedNames(t *testing.T) {
+	files := map[string]string{
+		"/download/v1.0":      "renamed",
+		"/src/foo.tar.gz":     "tarball",
+		"/src/foo.tar.gz.sig": "signature",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	t.Cleanup(srv.Close)
+
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	pkg := &Package{
+		Source: []string{
+			"foo-1.0.txt::" + srv.URL + "/download/v1.0",
+			srv.URL + "/src/foo.tar.gz{,.sig}",
+		},
+		SumAlgo:   "sha256",
+		Checksums: []string{sum("renamed"), sum("tarball"), sum("signature")},
+	}
+
+	pm := newTestPackageManager(t)
+	pm.download.Quiet = true
+	dir := t.TempDir()
+	if err := pm.fetchSources(downloadSources(pkg), dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"foo-1.0.txt", "foo.tar.gz", "foo.tar.gz.sig"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s was not downloaded: %v", name, err)
+		}
+	}
+	if unverified, err := verifySources(pkg, dir); err != nil || unverified != 0 {
+		t.Errorf("verifySources = %d, %v; want 0, nil", unverified, err)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+// Clock は現在時刻の取得元。テストでは固定時刻を返す実装に差し替える
+type Clock interface {
+	Now() time.Time
+}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+// now はDBに書き込む時刻を返す。SQLiteのCURRENT_TIMESTAMPに合わせてUTCに揃える
+func (pm *PackageManager) now() time.Time {
+	return pm.clock.Now().UTC()
+}
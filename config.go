@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config は設定ファイルに書く全体の既定値。コマンドラインのフラグがあればそちらを優先する
+type Config struct {
+	DownloadConcurrency int    `json:"download_concurrency"` // 同時に取得するソースの数
+	HTTPTimeoutSeconds  int    `json:"http_timeout_seconds"` // ダウンロードの無通信時間の上限
+	RetryCount          int    `json:"retry_count"`          // 一時的な失敗に対するダウンロードの再試行の回数（0なら再試行しない）
+	DefaultArch         string `json:"default_arch"`         // 空ならこのマシンのアーキテクチャ
+	AssumeYes           bool   `json:"assume_yes"`           // 確認を求めずに変更する
+	Proxy               string `json:"proxy"`                // 空なら環境変数（HTTP_PROXYなど）に従う
+}
+
+// defaultConfig は設定ファイルがないときの値。これまでの動作と同じになるようにしてある
+func defaultConfig() Config {
+	return Config{
+		DownloadConcurrency: defaultDownloadPolicy.Concurrency,
+		HTTPTimeoutSeconds:  int(defaultDownloadPolicy.Timeout / time.Second),
+		RetryCount:          defaultDownloadPolicy.Attempts - 1,
+	}
+}
+
+// loadConfig はpathの設定ファイルを読み込む。ファイルがなければ既定値を書き出して返す。
+// 書き出せない場合（読み取り専用で開いた場合など）も既定値で続ける
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := saveConfig(path, cfg); err != nil {
+			debugf("設定ファイルを書き出せません: %v\n", err)
+		}
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	// 書かれていない項目は既定値のまま残る
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("設定ファイル %s の解析に失敗: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("設定ファイル %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+func saveConfig(path string, cfg Config) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func (cfg Config) validate() error {
+	if cfg.DownloadConcurrency < 1 {
+		return fmt.Errorf("download_concurrency には1以上を指定してください")
+	}
+	if cfg.HTTPTimeoutSeconds < 1 {
+		return fmt.Errorf("http_timeout_seconds には1以上を指定してください")
+	}
+	if cfg.RetryCount < 0 {
+		return fmt.Errorf("retry_count には0以上を指定してください")
+	}
+	if cfg.Proxy != "" {
+		if _, err := parseProxy(cfg.Proxy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyConfig は設定ファイルの値をpmの既定値にする
+func (pm *PackageManager) applyConfig(cfg Config) {
+	pm.config = cfg
+	pm.download.Concurrency = cfg.DownloadConcurrency
+	pm.download.Timeout = time.Duration(cfg.HTTPTimeoutSeconds) * time.Second
+	pm.download.Attempts = cfg.RetryCount + 1 // 最初の1回を含む
+	pm.download.Proxy = cfg.Proxy
+	if cfg.DefaultArch != "" {
+		pm.arch = cfg.DefaultArch
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRetryCountCountsRetries(t *testing.T) {
+	for _, tc := range []struct {
+		retries, attempts int
+	}{
+		{0, 1},
+		{2, 3},
+	} {
+		path := filepath.Join(t.TempDir(), "config.json")
+		data := []byte(fmt.Sprintf(`{"retry_count": %d}`, tc.retries))
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := loadConfig(path)
+		if err != nil {
+			t.Fatalf("retry_count %d: %v", tc.retries, err)
+		}
+		pm := newTestPackageManager(t)
+		pm.applyConfig(cfg)
+		if pm.download.Attempts != tc.attempts {
+			t.Errorf("retry_count %d: Attempts = %d, want %d", tc.retries, pm.download.Attempts, tc.attempts)
+		}
+	}
+}
+
+func TestDefaultConfigKeepsDefaultAttempts(t *testing.T) {
+	pm := newTestPackageManager(t)
+	pm.applyConfig(defaultConfig())
+	if pm.download.Attempts != defaultDownloadPolicy.Attempts {
+		t.Errorf("Attempts = %d, want %d", pm.download.Attempts, defaultDownloadPolicy.Attempts)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// isTerminal はfが端末につながっているか判定する
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// confirmPlan は計画の内容とダウンロードの合計サイズを表示し、続行してよいか y/N で尋ねる。
+// インストールできないパッケージがある場合やinが端末でなければ尋ねずに断る
+func (pm *PackageManager) confirmPlan(in *os.File, w io.Writer, plan *Plan) bool {
+	var (
+		downloads, unknown int
+		total              int64
+	)
+	blocked := 0
+	fmt.Fprintln(w, "==> 以下の変更を行います")
+	for _, op := range plan.Operations {
+		fmt.Fprintf(w, "  %s %s %s (%s)", op.Action, op.Package, op.Version, op.Arch)
+		if op.Installed != "" {
+			fmt.Fprintf(w, " ← %s", op.Installed)
+		}
+		if op.Reason != "" {
+			fmt.Fprintf(w, "（%s）", op.Reason)
+		}
+		fmt.Fprintln(w)
+		switch op.Action {
+		case ActionSkip:
+		case ActionBlocked:
+			blocked++
+		default:
+			downloads += len(op.Downloads)
+			total += op.DownloadSize
+			unknown += op.DownloadSizeUnknown
+		}
+	}
+	if blocked > 0 {
+		fmt.Fprintf(w, "%d 個のパッケージはインストールできません\n", blocked)
+		return false
+	}
+
+	if downloads > 0 {
+		fmt.Fprintf(w, "ダウンロード: %d 個、合計 %s", downloads, humanizeBytes(total))
+		if unknown > 0 {
+			fmt.Fprintf(w, "（%d 個はサイズ不明）", unknown)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if !isTerminal(in) {
+		fmt.Fprintln(w, "標準入力が端末ではないため確認できません。続行するには --yes を指定してください")
+		return false
+	}
+
+	fmt.Fprint(w, "続行しますか? [y/N] ")
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// depOperators は依存関係で使える比較演算子。長いものから順に照合する
+var depOperators = []string{">=", "<=", "==", "=", ">", "<"}
+
+// parseDep は "foo>=1.2" を名前・演算子・バージョンに分ける。制約がなければopは空
+func parseDep(dep string) (name, op, version string) {
+	i := strings.IndexAny(dep, "<>=")
+	if i < 0 {
+		return dep, "", ""
+	}
+	for _, o := range depOperators {
+		if strings.HasPrefix(dep[i:], o) {
+			return dep[:i], o, dep[i+len(o):]
+		}
+	}
+	return dep[:i], "", ""
+}
+
+// satisfiesDep はversionが演算子opとwantの制約を満たすか判定する。
+// pkgrelを省いた制約（foo=1.2）はどのpkgrelとも一致する
+func satisfiesDep(version, op, want string) bool {
+	c := CompareVersions(version, want)
+	switch op {
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	case "=", "==":
+		return c == 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	}
+	return true
+}
+
+// provider はprovidesで名前を提供しているインストール済みパッケージ
+type provider struct {
+	Package string
+	Version string // "name=ver" で指定されたバージョン。なければ空
+}
+
+// installedProviders はインストール済みパッケージのprovidesを提供名ごとに返す
+func (pm *PackageManager) installedProviders() (map[string][]provider, error) {
+	rows, err := pm.db.Query(`
+		SELECT v.package_name, v.provides
+		FROM provides v
+		JOIN packages p ON p.name = v.package_name
+		WHERE p.installed = 1
+		ORDER BY v.package_name, v.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	providers := map[string][]provider{}
+	for rows.Next() {
+		var pkgName, prov string
+		if err := rows.Scan(&pkgName, &prov); err != nil {
+			return nil, err
+		}
+		name, _, version := parseDep(prov)
+		providers[name] = append(providers[name], provider{pkgName, version})
+	}
+	return providers, rows.Err()
+}
+
+// satisfiedByProvider は依存関係を満たすproviderを探す。
+// バージョン制約付きの依存関係はバージョン付きのprovidesでしか満たせない
+func satisfiedByProvider(providers []provider, op, want string) (provider, bool) {
+	for _, p := range providers {
+		if op == "" || (p.Version != "" && satisfiesDep(p.Version, op, want)) {
+			return p, true
+		}
+	}
+	return provider{}, false
+}
+
+// checkDependencies はpkgの依存関係とビルド依存のうち、インストール済みのパッケージが
+// バージョンの制約を満たしていないものをまとめてエラーにする。
+// 同名のパッケージがなくても、providesで名前を提供するパッケージがあれば満たされる。
+// frpmで管理していない依存先はシステム側で用意されているものとして扱う
+func (pm *PackageManager) checkDependencies(pkg *Package) error {
+	installed, err := pm.installedPackages()
+	if err != nil {
+		return err
+	}
+	versions := map[string]string{}
+	for _, p := range installed {
+		versions[p.Name] = p.FullVersion()
+	}
+	providers, err := pm.installedProviders()
+	if err != nil {
+		return err
+	}
+
+	problems := []string{}
+	for _, dep := range append(slices.Clone(pkg.Depends), pkg.MakeDepends...) {
+		name, op, want := parseDep(dep)
+		version, ok := versions[name]
+		if ok && satisfiesDep(version, op, want) {
+			continue
+		}
+		if p, found := satisfiedByProvider(providers[name], op, want); found {
+			debugf("依存関係 %s は %s が提供しています\n", dep, p.Package)
+			continue
+		}
+		switch {
+		case ok:
+			problems = append(problems, fmt.Sprintf("%s（インストール済み: %s）", dep, version))
+		case len(providers[name]) > 0:
+			problems = append(problems, fmt.Sprintf("%s（%s が提供していますがバージョンが合いません）", dep, providers[name][0].Package))
+		default:
+			debugf("依存関係 %s はfrpmで管理されていません\n", dep)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("依存関係の制約を満たしていません: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// dependencyGraph はインストール済みパッケージごとの依存先パッケージ名を返す。
+// 同名のパッケージがなくprovidesで提供されている依存先は、提供しているパッケージに置き換える
+func (pm *PackageManager) dependencyGraph() (map[string][]string, error) {
+	installed, err := pm.installedPackages()
+	if err != nil {
+		return nil, err
+	}
+	managed := map[string]bool{}
+	for _, p := range installed {
+		managed[p.Name] = true
+	}
+	providers, err := pm.installedProviders()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := pm.db.Query(`
+		SELECT d.package_name, d.depends_on
+		FROM dependencies d
+		JOIN packages p ON p.name = d.package_name
+		WHERE p.installed = 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	graph := map[string][]string{}
+	for rows.Next() {
+		var pkgName, dep string
+		if err := rows.Scan(&pkgName, &dep); err != nil {
+			return nil, err
+		}
+		name, op, want := parseDep(dep)
+		if !managed[name] {
+			if p, found := satisfiedByProvider(providers[name], op, want); found {
+				name = p.Package
+			}
+		}
+		if !slices.Contains(graph[pkgName], name) {
+			graph[pkgName] = append(graph[pkgName], name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, deps := range graph {
+		sort.Strings(deps)
+	}
+	return graph, nil
+}
+
+// reverseGraph は依存先から依存元を引けるように辺の向きを逆にする
+func reverseGraph(graph map[string][]string) map[string][]string {
+	rev := map[string][]string{}
+	for pkgName, deps := range graph {
+		for _, dep := range deps {
+			rev[dep] = append(rev[dep], pkgName)
+		}
+	}
+	for _, dependents := range rev {
+		sort.Strings(dependents)
+	}
+	return rev
+}
+
+// closure はrootから辿れる全てのノードをroot自身を除いて名前順で返す
+func closure(graph map[string][]string, root string) []string {
+	seen := map[string]bool{root: true}
+	queue := []string{root}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, next := range graph[cur] {
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	delete(seen, root)
+	result := []string{}
+	for name := range seen {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// ReverseDependencies はpkgNameを削除すると依存関係が壊れるインストール済みパッケージを
+// 推移的に全て返す
+func (pm *PackageManager) ReverseDependencies(pkgName string) ([]string, error) {
+	graph, err := pm.dependencyGraph()
+	if err != nil {
+		return nil, err
+	}
+	return closure(reverseGraph(graph), pkgName), nil
+}
+
+// ShowDependencies はpkgNameの依存関係（reverseなら逆依存）をツリーまたは一覧で表示する
+func (pm *PackageManager) ShowDependencies(pkgName string, reverse, flat bool) error {
+	if !pm.isInstalled(pkgName) {
+		return fmt.Errorf("パッケージ %s はインストールされていません", pkgName)
+	}
+
+	graph, err := pm.dependencyGraph()
+	if err != nil {
+		return err
+	}
+	if reverse {
+		graph = reverseGraph(graph)
+	}
+
+	// reverseならReverseDependenciesと同じ一覧になる
+	if flat {
+		printNames(closure(graph, pkgName))
+		return nil
+	}
+
+	fmt.Println(pkgName)
+	printTree(graph, pkgName, "", map[string]bool{pkgName: true})
+	return nil
+}
+
+// printNames はパッケージ名を1行ずつ表示する
+func printNames(names []string) {
+	if len(names) == 0 {
+		fmt.Println("(なし)")
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+// printTree は依存関係をツリー表示する。既に表示したパッケージは展開しない
+func printTree(graph map[string][]string, node, prefix string, seen map[string]bool) {
+	children := graph[node]
+	for i, child := range children {
+		branch, indent := "├── ", "│   "
+		if i == len(children)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		if seen[child] {
+			fmt.Printf("%s%s%s (表示済み)\n", prefix, branch, child)
+			continue
+		}
+		seen[child] = true
+
+		fmt.Printf("%s%s%s\n", prefix, branch, child)
+		printTree(graph, child, prefix+indent, seen)
+	}
+}
+
+// ExportGraph はインストール済みパッケージの依存関係をGraphViz DOT形式で書き出す。
+// rootが空でなければそのパッケージから辿れる範囲に限定する。
+// frpmで管理していない依存先は破線で表す
+func (pm *PackageManager) ExportGraph(w io.Writer, root string) error {
+	graph, err := pm.dependencyGraph()
+	if err != nil {
+		return err
+	}
+
+	installed, err := pm.installedPackages()
+	if err != nil {
+		return err
+	}
+	managed := map[string]bool{}
+	for _, pkg := range installed {
+		managed[pkg.Name] = true
+	}
+
+	nodes := []string{}
+	if root != "" {
+		if !managed[root] {
+			return fmt.Errorf("パッケージ %s はインストールされていません", root)
+		}
+		nodes = append([]string{root}, closure(graph, root)...)
+		sort.Strings(nodes)
+	} else {
+		seen := map[string]bool{}
+		for name := range managed {
+			seen[name] = true
+			for _, dep := range graph[name] {
+				seen[dep] = true
+			}
+		}
+		for name := range seen {
+			nodes = append(nodes, name)
+		}
+		sort.Strings(nodes)
+	}
+
+	fmt.Fprintln(w, "digraph packages {")
+	fmt.Fprintln(w, "\trankdir=LR;")
+	for _, name := range nodes {
+		if managed[name] {
+			fmt.Fprintf(w, "\t%q;\n", name)
+		} else {
+			fmt.Fprintf(w, "\t%q [style=dashed];\n", name)
+		}
+	}
+	for _, name := range nodes {
+		for _, dep := range graph[name] {
+			fmt.Fprintf(w, "\t%q -> %q;\n", name, dep)
+		}
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
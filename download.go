@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ダウンロード失敗時の方針
+const (
+	OnErrorAbort    = "abort"    // 1件でも取得できなければインストールを中止する
+	OnErrorContinue = "continue" // 失敗したものは後回しにして残りを取得し、最後に報告して中止する
+)
+
+// DownloadPolicy はソースのダウンロードに失敗した場合の扱い
+type DownloadPolicy struct {
+	OnError     string
+	Attempts    int           // 一時的な失敗に対する試行回数（1回目を含む）
+	Timeout     time.Duration // 応答やデータの受信がこの間途絶えたら失敗とする
+	Concurrency int           // 同時に取得するソースの数。2以上では進捗を表示しない
+	// CrossHost は別のホストへのリダイレクトを許可する。httpsからhttpへは許可しない
+	CrossHost bool
+	Quiet     bool   // ダウンロードの進捗を表示しない
+	Proxy     string // 空でなければ環境変数（HTTP_PROXYなど）より優先して使うプロキシのURL
+}
+
+var defaultDownloadPolicy = DownloadPolicy{
+	OnError:     OnErrorAbort,
+	Attempts:    3,
+	Timeout:     30 * time.Second,
+	Concurrency: 1,
+}
+
+// maxRedirects は1回のダウンロードで辿るリダイレクトの上限
+const maxRedirects = 10
+
+// errRedirectRefused はcheckRedirectが拒否したリダイレクト。再試行しても結果は変わらない
+var errRedirectRefused = errors.New("リダイレクトを拒否しました")
+
+// newHTTPClient はダウンロードで共有するHTTPクライアントを作る。
+// 大きなファイルを途中で打ち切らないよう、Client.Timeoutは使わずリクエストごとに無通信時間で判定する。
+// リダイレクトの可否とプロキシはpolicyをその都度参照して決める
+func newHTTPClient(policy *DownloadPolicy) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if policy.Proxy != "" {
+			return parseProxy(policy.Proxy)
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return checkRedirect(req, via, policy.CrossHost)
+		},
+	}
+}
+
+// parseProxy はプロキシのURLを解析する。スキームを省略した "host:port" はhttpとみなす
+func parseProxy(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("プロキシのURLが不正です: %v", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("プロキシのURLにホストがありません: %s", raw)
+	}
+	return u, nil
+}
+
+// checkRedirect はhttpsからhttpへの格下げと、許可されていない別ホストへのリダイレクトを拒否する
+func checkRedirect(req *http.Request, via []*http.Request, crossHost bool) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("%w: %d 回を超えました", errRedirectRefused, maxRedirects)
+	}
+
+	prev, orig := via[len(via)-1], via[0]
+	if prev.URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: httpsからhttpへの変更（%s）", errRedirectRefused, req.URL)
+	}
+	if !crossHost && req.URL.Hostname() != orig.URL.Hostname() {
+		return fmt.Errorf("%w: 別のホスト %s へ（許可するには --allow-cross-host-redirect）", errRedirectRefused, req.URL.Hostname())
+	}
+	return nil
+}
+
+// idleReader は読み込めるたびにtimerをtimeoutに戻す。timerが切れるとリクエストが取り消される
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 {
+		ir.timer.Reset(ir.timeout)
+	}
+	return n, err
+}
+
+// progressReader は読み込んだバイト数をContent-Lengthと合わせて同じ行に上書き表示する
+type progressReader struct {
+	r     io.Reader
+	w     io.Writer
+	total int64 // 不明なら-1
+	done  int64
+	last  time.Time
+}
+
+// progressInterval は進捗表示を更新する最短の間隔
+const progressInterval = 100 * time.Millisecond
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.done += int64(n)
+	if now := time.Now(); now.Sub(pr.last) >= progressInterval {
+		pr.last = now
+		pr.print()
+	}
+	return n, err
+}
+
+func (pr *progressReader) print() {
+	if pr.total > 0 {
+		fmt.Fprintf(pr.w, "\r     %s / %s (%d%%)", humanizeBytes(pr.done), humanizeBytes(pr.total), pr.done*100/pr.total)
+	} else {
+		fmt.Fprintf(pr.w, "\r     %s", humanizeBytes(pr.done))
+	}
+}
+
+// finish は最終的な値を表示して改行する
+func (pr *progressReader) finish() {
+	pr.print()
+	fmt.Fprintln(pr.w)
+}
+
+// timeoutError は無通信で取り消されたリクエストのエラーを分かりやすいものに置き換える
+func timeoutError(ctx context.Context, err error, timeout time.Duration) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return fmt.Errorf("%s の間応答がありませんでした", timeout)
+	}
+	return err
+}
+
+// downloadError はダウンロードの失敗。Permanentなもの（404など）は再試行しない
+type downloadError struct {
+	Err       error
+	Permanent bool
+}
+
+func (e *downloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *downloadError) Unwrap() error {
+	return e.Err
+}
+
+func isPermanent(err error) bool {
+	var dlErr *downloadError
+	return errors.As(err, &dlErr) && dlErr.Permanent
+}
+
+// fetchSources はURLを順に取得する。失敗時の扱いはpm.downloadに従うが、
+// 最終的に取得できなかったソースがあればどちらでもエラーを返す
+func (pm *PackageManager) fetchSources(urls []string, destDir string) error {
+	type failure struct {
+		url string
+		err error
+	}
+	failures := []failure{}
+	deferred := []string{}
+
+	errs := pm.downloadAll(urls, destDir)
+	for i, url := range urls {
+		err := errs[i]
+		if err == nil {
+			continue
+		}
+		if pm.download.OnError != OnErrorContinue {
+			return fmt.Errorf("%sのダウンロードに失敗: %v", url, err)
+		}
+
+		if isPermanent(err) {
+			failures = append(failures, failure{url, err})
+		} else {
+			fmt.Printf("警告: %sのダウンロードに失敗、後で再試行します: %v\n", url, err)
+			deferred = append(deferred, url)
+		}
+	}
+
+	// 一時的な失敗は他のソースを取得し終えてからもう一度試す
+	for _, url := range deferred {
+		if err := pm.downloadWithRetry(url, destDir); err != nil {
+			failures = append(failures, failure{url, err})
+		}
+	}
+
+	if len(failures) > 0 {
+		fmt.Println("以下のソースを取得できませんでした:")
+		urls := []string{}
+		for _, f := range failures {
+			kind := "一時的"
+			if isPermanent(f.err) {
+				kind = "恒久的"
+			}
+			fmt.Printf("  %s: %v（%s）\n", f.url, f.err, kind)
+			urls = append(urls, f.url)
+		}
+		// continueは残りを取得してから報告するという意味で、欠けたままインストールはしない
+		return fmt.Errorf("%d 個のソースを取得できませんでした: %s", len(failures), strings.Join(urls, ", "))
+	}
+
+	return nil
+}
+
+// downloadAll はurlsをpm.download.Concurrency件まで並行に取得し、URLごとの結果を返す。
+// abortの場合は1件失敗した時点で新たな取得を始めない（始めなかったものの結果はnil）
+func (pm *PackageManager) downloadAll(urls []string, destDir string) []error {
+	errs := make([]error, len(urls))
+	sem := make(chan struct{}, max(pm.download.Concurrency, 1))
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+	)
+	for i, url := range urls {
+		sem <- struct{}{}
+		mu.Lock()
+		stop := failed && pm.download.OnError != OnErrorContinue
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = pm.downloadWithRetry(url, destDir)
+			if errs[i] != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// downloadWithRetry は一時的な失敗を指数バックオフで再試行する
+func (pm *PackageManager) downloadWithRetry(url, destDir string) error {
+	attempts := max(pm.download.Attempts, 1)
+	backoff := time.Second
+
+	var err error
+	for i := 1; i <= attempts; i++ {
+		err = pm.downloadSource(url, destDir)
+		if err == nil || isPermanent(err) {
+			return err
+		}
+		if i < attempts {
+			fmt.Printf("  -> 失敗しました（%v）、%s後に再試行します（%d/%d）\n", err, backoff, i+1, attempts)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// downloadSize はurlsの合計サイズをHEADリクエストのContent-Lengthから求める。
+// サイズがわからなかったURLの数もあわせて返す
+func (pm *PackageManager) downloadSize(urls []string) (total int64, unknown int) {
+	for _, url := range urls {
+		ctx, cancel := context.WithTimeout(context.Background(), pm.download.Timeout)
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		var resp *http.Response
+		if err == nil {
+			resp, err = pm.client.Do(req)
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+
+		if err != nil || resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+			debugf("%s のサイズを取得できません\n", url)
+			unknown++
+			continue
+		}
+		total += resp.ContentLength
+	}
+	return total, unknown
+}
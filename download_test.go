@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// stubProxy は受け取ったリクエストのホストを記録し、どのURLにも同じ内容を返すプロキシ
+type stubProxy struct {
+	*httptest.Server
+	mu    sync.Mutex
+	hosts []string
+}
+
+func newStubProxy(t *testing.T) *stubProxy {
+	t.Helper()
+	p := &stubProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.hosts = append(p.hosts, r.URL.Host)
+		p.mu.Unlock()
+		w.Write([]byte("via proxy"))
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// originURL は実在しないホストなので、プロキシを経由しなければ取得できない
+const originURL = "http://origin.invalid/src.tar.gz"
+
+// checkProxied はoriginURLがproxyを経由して取得されたことを確かめる
+func checkProxied(t *testing.T, p *stubProxy, destDir string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(destDir, "src.tar.gz"))
+	if err != nil || string(data) != "via proxy" {
+		t.Errorf("downloaded %q (err=%v), want the proxy's response", data, err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.hosts) != 1 || p.hosts[0] != "origin.invalid" {
+		t.Errorf("proxy saw hosts %q, want [origin.invalid]", p.hosts)
+	}
+}
+
+func TestDownloadUsesConfiguredProxy(t *testing.T) {
+	proxy := newStubProxy(t)
+	pm := newTestPackageManager(t)
+	pm.download.Quiet = true
+	pm.download.Proxy = proxy.URL // install --proxy
+
+	destDir := t.TempDir()
+	if err := pm.downloadSource(originURL, destDir); err != nil {
+		t.Fatal(err)
+	}
+	checkProxied(t, proxy, destDir)
+}
+
+// http.ProxyFromEnvironmentは環境変数を最初の1回しか読まないので、
+// HTTP_PROXYを設定した別プロセスでテストを実行する
+func TestDownloadUsesProxyFromEnvironment(t *testing.T) {
+	if dest := os.Getenv("FRPM_TEST_PROXY_DEST"); dest != "" {
+		pm := newTestPackageManager(t)
+		pm.download.Quiet = true
+		if err := pm.downloadSource(originURL, dest); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	proxy := newStubProxy(t)
+	destDir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDownloadUsesProxyFromEnvironment$")
+	cmd.Env = append(os.Environ(), "HTTP_PROXY="+proxy.URL, "FRPM_TEST_PROXY_DEST="+destDir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	checkProxied(t, proxy, destDir)
+}
+
+func TestConfiguredProxyOverridesEnvironment(t *testing.T) {
+	if dest := os.Getenv("FRPM_TEST_PROXY_DEST"); dest != "" {
+		pm := newTestPackageManager(t)
+		pm.download.Quiet = true
+		pm.download.Proxy = os.Getenv("FRPM_TEST_PROXY")
+		if err := pm.downloadSource(originURL, dest); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	proxy, unused := newStubProxy(t), newStubProxy(t)
+	destDir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestConfiguredProxyOverridesEnvironment$")
+	cmd.Env = append(os.Environ(), "HTTP_PROXY="+unused.URL, "FRPM_TEST_PROXY="+proxy.URL, "FRPM_TEST_PROXY_DEST="+destDir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	checkProxied(t, proxy, destDir)
+	if len(unused.hosts) != 0 {
+		t.Errorf("HTTP_PROXY was used despite --proxy: %q", unused.hosts)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Durability はインストール時にファイルをどの単位でディスクに同期（fsync）するか
+type Durability string
+
+const (
+	// DurabilityNone は同期をOSに任せる。最速だが、クラッシュすると直前に書いたファイルが
+	// 空や途中の内容で残り得る。DBにはインストール済みと記録されるので verify で検出する
+	DurabilityNone Durability = "none"
+	// DurabilityPerPackage はパッケージの全ファイルを一時ファイルとして書き終えてから
+	// それぞれを一度ずつ同期し、置き換えた後に書き込んだディレクトリを一度ずつ同期する。
+	// 書くたびに同期を待たないぶんper-fileより速い。DBへの登録は同期の後なので、
+	// 登録済みのパッケージのファイルは必ずディスク上に揃っている。書き込み中に
+	// クラッシュしたパッケージは未登録のまま残り、再インストールで回復できる
+	DurabilityPerPackage Durability = "per-package"
+	// DurabilityPerFile はファイルを書くたびに内容とディレクトリエントリを同期する。
+	// クラッシュしてもそれまでに書いたファイルは完全だが、小さなファイルが多いと遅い
+	DurabilityPerFile Durability = "per-file"
+)
+
+func parseDurability(s string) (Durability, error) {
+	switch d := Durability(s); d {
+	case DurabilityNone, DurabilityPerPackage, DurabilityPerFile:
+		return d, nil
+	}
+	return "", fmt.Errorf("不明な同期レベル: %s（none, per-package, per-file のいずれか）", s)
+}
+
+// syncPath はファイルまたはディレクトリをディスクに同期する
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
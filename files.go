@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InstalledFile はインストールしたファイル1つの記録。PathはinstallRootからの相対パス
+type InstalledFile struct {
+	Path    string
+	Size    int64
+	ModTime int64 // UnixNano
+	SHA256  string
+}
+
+// stagedFile は置き換え待ちのファイル。tmpは置き換え先と同じディレクトリに作った一時ファイル
+type stagedFile struct {
+	InstalledFile
+	tmp, dst string
+}
+
+// stageFile はsrcをdstと同じディレクトリの一時ファイルにコピーし、置き換え待ちの記録を返す。
+// syncなら一時ファイルの内容をディスクに同期してから返す。dst自体にはまだ触れない
+func stageFile(src, dst, relPath string, sync bool) (stagedFile, error) {
+	os.MkdirAll(filepath.Dir(dst), 0755)
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return stagedFile{}, err
+	}
+	defer srcFile.Close()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".frpm-*")
+	if err != nil {
+		return stagedFile{}, err
+	}
+	defer tmpFile.Close()
+
+	staged, err := copyToStaged(srcFile, tmpFile, sync)
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return stagedFile{}, err
+	}
+	staged.Path, staged.tmp, staged.dst = relPath, tmpFile.Name(), dst
+	return staged, nil
+}
+
+func copyToStaged(srcFile, tmpFile *os.File, sync bool) (stagedFile, error) {
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmpFile, h), srcFile); err != nil {
+		return stagedFile{}, err
+	}
+
+	srcInfo, err := srcFile.Stat()
+	if err != nil {
+		return stagedFile{}, err
+	}
+	if err := tmpFile.Chmod(srcInfo.Mode()); err != nil {
+		return stagedFile{}, err
+	}
+
+	if sync {
+		if err := tmpFile.Sync(); err != nil {
+			return stagedFile{}, err
+		}
+	}
+
+	tmpInfo, err := tmpFile.Stat()
+	if err != nil {
+		return stagedFile{}, err
+	}
+
+	return stagedFile{InstalledFile: InstalledFile{
+		Size:    tmpInfo.Size(),
+		ModTime: tmpInfo.ModTime().UnixNano(),
+		SHA256:  hex.EncodeToString(h.Sum(nil)),
+	}}, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// packageFiles はパッケージの記録済みファイルをパス順で返す
+func (pm *PackageManager) packageFiles(pkgName string) ([]InstalledFile, error) {
+	rows, err := pm.db.Query(`
+		SELECT path, size, mtime, sha256 FROM files WHERE package_name = ? ORDER BY path
+	`, pkgName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []InstalledFile{}
+	for rows.Next() {
+		var f InstalledFile
+		if err := rows.Scan(&f.Path, &f.Size, &f.ModTime, &f.SHA256); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// ListFiles はパッケージがインストールしたファイルの絶対パスをパス順で返す
+func (pm *PackageManager) ListFiles(pkgName string) ([]string, error) {
+	if !pm.isInstalled(pkgName) {
+		return nil, fmt.Errorf("パッケージ %s はインストールされていません", pkgName)
+	}
+
+	files, err := pm.packageFiles(pkgName)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, f := range files {
+		paths = append(paths, filepath.Join(pm.installRoot, f.Path))
+	}
+	return paths, nil
+}
+
+// WhichPackageOwns はpathをインストールしたパッケージの名前を返す。
+// 絶対パスはinstallRootからの相対パスに直し、相対パスはinstallRootからのものとみなす
+func (pm *PackageManager) WhichPackageOwns(path string) (string, error) {
+	relPath := filepath.Clean(path)
+	if filepath.IsAbs(relPath) {
+		rel, err := filepath.Rel(pm.installRoot, relPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", fmt.Errorf("%s は %s の外にあるためどのパッケージにも属しません", path, pm.installRoot)
+		}
+		relPath = rel
+	}
+
+	var owner string
+	err := pm.db.QueryRow(`
+		SELECT f.package_name
+		FROM files f
+		JOIN packages p ON p.name = f.package_name
+		WHERE f.path = ? AND p.installed = 1
+		ORDER BY f.package_name
+		LIMIT 1
+	`, relPath).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%s を所有するパッケージはありません", path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return owner, nil
+}
+
+func insertFiles(tx *sql.Tx, pkgName string, files []InstalledFile) error {
+	stmt, err := tx.Prepare(`
+		INSERT INTO files (package_name, path, size, mtime, sha256) VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, f := range files {
+		if _, err := stmt.Exec(pkgName, f.Path, f.Size, f.ModTime, f.SHA256); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStaleFiles は古いバージョンにあって新しいバージョンにないファイルを削除する。
+// 他のパッケージも記録しているファイルは残す
+func (pm *PackageManager) removeStaleFiles(pkgName string, oldFiles, newFiles []InstalledFile) error {
+	keep := map[string]bool{}
+	for _, f := range newFiles {
+		keep[f.Path] = true
+	}
+
+	for _, f := range oldFiles {
+		if keep[f.Path] {
+			continue
+		}
+		var owners int
+		err := pm.db.QueryRow(`
+			SELECT COUNT(*) FROM files WHERE path = ? AND package_name != ?
+		`, f.Path, pkgName).Scan(&owners)
+		if err != nil {
+			return err
+		}
+		if owners > 0 {
+			continue
+		}
+		if err := os.Remove(filepath.Join(pm.installRoot, f.Path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		debugf("古いファイルを削除: %s\n", f.Path)
+	}
+	return nil
+}
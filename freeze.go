@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// freezeState はシステム全体の凍結状態
+type freezeState struct {
+	Reason    string
+	FrozenAt  time.Time
+	ExpiresAt time.Time // ゼロ値は無期限
+}
+
+// Freeze はシステムを凍結し、thawされるまで変更を伴うコマンドを拒否させる
+func (pm *PackageManager) Freeze(reason string, until time.Time) error {
+	unlock, err := pm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var expiresAt interface{}
+	if !until.IsZero() {
+		expiresAt = until.UTC()
+	}
+
+	_, err = pm.db.Exec(`
+		INSERT OR REPLACE INTO freeze (id, reason, frozen_at, expires_at)
+		VALUES (1, ?, ?, ?)
+	`, reason, pm.now(), expiresAt)
+	if err != nil {
+		return permissionError(err)
+	}
+
+	fmt.Println("システムを凍結しました")
+	return nil
+}
+
+func (pm *PackageManager) Thaw() error {
+	unlock, err := pm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	res, err := pm.db.Exec(`DELETE FROM freeze WHERE id = 1`)
+	if err != nil {
+		return permissionError(err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		fmt.Println("システムは凍結されていません")
+		return nil
+	}
+
+	fmt.Println("システムの凍結を解除しました")
+	return nil
+}
+
+func (pm *PackageManager) FreezeStatus() error {
+	st, err := pm.getFreeze()
+	if err != nil {
+		return err
+	}
+
+	if st == nil {
+		fmt.Println("システムは凍結されていません")
+		return nil
+	}
+
+	fmt.Println("システムは凍結されています")
+	if st.Reason != "" {
+		fmt.Printf("理由: %s\n", st.Reason)
+	}
+	fmt.Printf("凍結日時: %s\n", formatTime(st.FrozenAt))
+	if st.ExpiresAt.IsZero() {
+		fmt.Println("期限: なし")
+	} else {
+		fmt.Printf("期限: %s\n", formatTime(st.ExpiresAt))
+	}
+
+	return nil
+}
+
+// getFreeze は有効な凍結状態を返す。凍結されていないか期限切れの場合はnil
+func (pm *PackageManager) getFreeze() (*freezeState, error) {
+	var st freezeState
+	var reason sql.NullString
+	var expiresAt sql.NullTime
+	err := pm.db.QueryRow(`
+		SELECT reason, frozen_at, expires_at FROM freeze WHERE id = 1
+	`).Scan(&reason, &st.FrozenAt, &expiresAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	st.Reason = reason.String
+	if expiresAt.Valid {
+		st.ExpiresAt = expiresAt.Time
+		if pm.clock.Now().After(st.ExpiresAt) {
+			return nil, nil
+		}
+	}
+
+	return &st, nil
+}
+
+// checkFrozen はシステムが凍結中ならエラーを返す。変更を伴う操作の先頭で呼ぶ
+func (pm *PackageManager) checkFrozen() error {
+	st, err := pm.getFreeze()
+	if err != nil {
+		return fmt.Errorf("凍結状態の確認に失敗: %v", err)
+	}
+	if st == nil {
+		return nil
+	}
+
+	msg := "システムは凍結されています"
+	if st.Reason != "" {
+		msg += fmt.Sprintf("（理由: %s）", st.Reason)
+	}
+	return fmt.Errorf("%s。解除するには thaw を実行してください", msg)
+}
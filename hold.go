@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Hold はパッケージを保持し、unholdされるまでinstallで置き換えられないようにする
+func (pm *PackageManager) Hold(pkgName string) error {
+	return pm.setHeld(pkgName, true)
+}
+
+func (pm *PackageManager) Unhold(pkgName string) error {
+	return pm.setHeld(pkgName, false)
+}
+
+func (pm *PackageManager) setHeld(pkgName string, held bool) error {
+	unlock, err := pm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := pm.checkFrozen(); err != nil {
+		return err
+	}
+
+	pkg, ok, err := pm.GetInstalled(pkgName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("パッケージ %s はインストールされていません", pkgName)
+	}
+	if pkg.Held == held {
+		if held {
+			fmt.Printf("パッケージ %s は既に保持されています\n", pkgName)
+		} else {
+			fmt.Printf("パッケージ %s は保持されていません\n", pkgName)
+		}
+		return nil
+	}
+
+	if _, err := pm.db.Exec(`UPDATE packages SET held = ? WHERE name = ?`, held, pkgName); err != nil {
+		return permissionError(err)
+	}
+
+	if held {
+		fmt.Printf("パッケージ %s を %s で保持しました\n", pkgName, pkg.FullVersion())
+	} else {
+		fmt.Printf("パッケージ %s の保持を解除しました\n", pkgName)
+	}
+	return nil
+}
+
+// ListHeld は保持されているパッケージを名前順に返す
+func (pm *PackageManager) ListHeld() ([]*Package, error) {
+	pkgs, err := pm.installedPackages()
+	if err != nil {
+		return nil, err
+	}
+
+	held := []*Package{}
+	for _, pkg := range pkgs {
+		if pkg.Held {
+			held = append(held, pkg)
+		}
+	}
+	return held, nil
+}
+
+// checkHeld はパッケージが保持されていればエラーを返す
+func checkHeld(pkg *Package) error {
+	if pkg.Held {
+		return fmt.Errorf("パッケージ %s は %s で保持されています。更新するには unhold を実行してください", pkg.Name, pkg.FullVersion())
+	}
+	return nil
+}
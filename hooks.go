@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// loadInstallScript はPKGBUILDの install= で指定されたスクリプトから
+// pre_install()、post_install()、pre_upgrade()、post_upgrade()を読み込む。
+// パスはPKGBUILDからの相対パス
+func loadInstallScript(pkg *Package, pkgbuildPath, name string) error {
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(pkgbuildPath), name)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("installスクリプトの読み込みに失敗: %v", err)
+	}
+
+	text := string(content)
+	pkg.PreInstall = extractBashFunction(text, "pre_install")
+	pkg.PostInstall = extractBashFunction(text, "post_install")
+	pkg.PreUpgrade = extractBashFunction(text, "pre_upgrade")
+	pkg.PostUpgrade = extractBashFunction(text, "post_upgrade")
+	debugf("installスクリプト %s: pre_install=%t, post_install=%t, pre_upgrade=%t, post_upgrade=%t\n", path,
+		pkg.PreInstall != "", pkg.PostInstall != "", pkg.PreUpgrade != "", pkg.PostUpgrade != "")
+	return nil
+}
+
+// scriptHook はinstallスクリプトの関数1つ。Bodyが空なら実行しない
+type scriptHook struct {
+	Name string
+	Body string
+}
+
+// installHooks はファイルのインストール前後に実行する関数を返す。pacmanと同様に、
+// 既にインストールされていれば（同じバージョンの再インストールも含む）*_upgradeを、
+// 新規インストールなら*_installを使う
+func installHooks(pkg *Package, upgrade bool) (pre, post scriptHook) {
+	if upgrade {
+		return scriptHook{"pre_upgrade", pkg.PreUpgrade}, scriptHook{"post_upgrade", pkg.PostUpgrade}
+	}
+	return scriptHook{"pre_install", pkg.PreInstall}, scriptHook{"post_install", pkg.PostInstall}
+}
+
+// runHook はinstallスクリプトの関数をインストール先のルートで実行する。
+// 引数にはpacmanと同様に新しいバージョンを、アップグレードなら続けて古いバージョンを渡す。
+// 環境変数とタイムアウトはサンドボックスの設定に従うが、インストール先を操作するための
+// ものなので実行ユーザーは切り替えない
+func (pm *PackageManager) runHook(hook scriptHook, pkg *Package, oldVersion string) error {
+	args := []string{pkg.FullVersion()}
+	if oldVersion != "" {
+		args = append(args, oldVersion)
+	}
+
+	script := fmt.Sprintf(`
+set -e
+%s() {
+%s
+}
+echo "==> %s()を実行します..."
+%s "$@"
+`, hook.Name, hook.Body, hook.Name, hook.Name)
+
+	debugf("実行するスクリプト:\n%s\n", script)
+
+	ctx, cancel := pm.sandbox.context()
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "bash", append([]string{"-c", script, hook.Name}, args...)...)
+	cmd.Dir = pm.installRoot
+	cmd.Env = append(pm.sandbox.env(pm.installRoot),
+		fmt.Sprintf("pkgname=%s", pkg.Name),
+		fmt.Sprintf("pkgver=%s", pkg.Version),
+		fmt.Sprintf("pkgrel=%s", pkg.Release),
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = 5 * time.Second
+
+	return pm.sandbox.scriptError(ctx, cmd.Run())
+}
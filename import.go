@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// importCommands はImportがパッケージ一覧を取得するために実行するコマンド。
+// 出力は1行に「名前 バージョン [アーキテクチャ]」。バージョンはそのまま記録し、以後は
+// CompareVersions（pacmanの規則）で比較するので、dpkgやrpmの順序とは一致しないことがある
+var importCommands = map[string][]string{
+	"pacman": {"pacman", "-Q"},
+	"dpkg":   {"dpkg-query", "-W", "-f", "${Package} ${Version} ${Architecture}\n"},
+	"rpm":    {"rpm", "-qa", "--qf", "%{NAME} %{VERSION}-%{RELEASE} %{ARCH}\n"},
+}
+
+// ImportFrom は他のパッケージマネージャーのコマンドを一度実行し、その結果をImportする
+func (pm *PackageManager) ImportFrom(tool, reason string) error {
+	args, ok := importCommands[tool]
+	if !ok {
+		return fmt.Errorf("不明なパッケージマネージャー: %s（pacman, dpkg, rpm のいずれか）", tool)
+	}
+
+	out, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		return fmt.Errorf("%sの実行に失敗: %v", args[0], err)
+	}
+	return pm.Import(bytes.NewReader(out), tool, reason)
+}
+
+// Import は「名前 バージョン [アーキテクチャ]」形式の一覧を読み、インストール済みとして登録する。
+// 余分な列やヘッダー行は無視し、既に登録済みのパッケージは上書きしない。
+// originはインストール元として記録する一覧の出どころ（ファイル名やpacmanなど）、
+// reasonは記録するインストールの理由（通常はReasonImported）
+func (pm *PackageManager) Import(r io.Reader, origin, reason string) error {
+	unlock, err := pm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := pm.checkFrozen(); err != nil {
+		return err
+	}
+
+	pkgs, skipped, err := parseInstalledList(r)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pm.db.Begin()
+	if err != nil {
+		return permissionError(err)
+	}
+	defer tx.Rollback()
+
+	imported, existing := 0, 0
+	for _, pkg := range pkgs {
+		res, err := tx.Exec(`
+			INSERT OR IGNORE INTO packages (name, version, release, arch, installed, installed_at, origin, reason)
+			VALUES (?, ?, ?, ?, 1, ?, ?, ?)
+		`, pkg.Name, pkg.Version, pkg.Release, pkg.Arch, pm.now(), "import:"+origin, reason)
+		if err != nil {
+			return fmt.Errorf("%sの登録に失敗: %v", pkg.Name, permissionError(err))
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			existing++
+			continue
+		}
+		if strings.Contains(pkg.Version, "~") {
+			fmt.Printf("警告: %s のバージョン %s はpacmanの規則で比較するため、~ の扱いがdpkgと異なります\n",
+				pkg.Name, pkg.FullVersion())
+		}
+		imported++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return permissionError(err)
+	}
+
+	fmt.Printf("%d 個のパッケージを登録しました（登録済み: %d, 解釈できない行: %d）\n", imported, existing, skipped)
+	return nil
+}
+
+// parseInstalledList は一覧を解析する。dpkg -l のヘッダーと状態列（"ii"など）、
+// パッケージ名の ":amd64" のようなアーキテクチャ修飾も扱う
+func parseInstalledList(r io.Reader) ([]*Package, int, error) {
+	pkgs := []*Package{}
+	skipped := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.ContainsAny(line[:1], "|+") ||
+			strings.HasPrefix(line, "Desired=") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields[0]) == 2 && strings.Trim(fields[0], "uihrpncfWt") == "" {
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			fmt.Printf("警告: 解釈できない行をスキップ: %s\n", line)
+			skipped++
+			continue
+		}
+
+		pkg := &Package{Name: fields[0], Arch: hostArch()}
+		if name, arch, ok := strings.Cut(fields[0], ":"); ok {
+			pkg.Name, pkg.Arch = name, arch
+		}
+		pkg.Version, pkg.Release = splitVersionRelease(fields[1])
+		if len(fields) >= 3 {
+			pkg.Arch = fields[2]
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	return pkgs, skipped, scanner.Err()
+}
+
+// splitVersionRelease は "1.2.3-4" をバージョン "1.2.3" とリリース "4" に分ける
+func splitVersionRelease(v string) (string, string) {
+	if i := strings.LastIndex(v, "-"); i > 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
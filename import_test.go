@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportRecordsReason(t *testing.T) {
+	pm := newTestPackageManager(t)
+
+	for _, tc := range []struct{ list, reason string }{
+		{"zlib 1.3-1 x86_64\n", ReasonImported},
+		{"bash 5.2-1 x86_64\n", ReasonExplicit},
+		{"readline 8.2-1 x86_64\n", ReasonDependency},
+	} {
+		if err := pm.Import(strings.NewReader(tc.list), "test", tc.reason); err != nil {
+			t.Fatal(err)
+		}
+		name := strings.Fields(tc.list)[0]
+		pkg, ok, err := pm.GetInstalled(name)
+		if err != nil || !ok {
+			t.Fatalf("%s is not registered (err=%v)", name, err)
+		}
+		if pkg.Reason != tc.reason {
+			t.Errorf("%s reason = %q, want %q", name, pkg.Reason, tc.reason)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lock は変更を伴う操作の間、他のfrpmプロセスを締め出す排他ロックを取る。
+// 取れなければ待たずにエラーを返す。ロックはflockなので、プロセスが異常終了しても
+// OSが解放する。返された関数で解放する
+func (pm *PackageManager) lock() (func(), error) {
+	if pm.lockPath == "" {
+		return func() {}, nil
+	}
+
+	f, err := os.OpenFile(pm.lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, permissionError(fmt.Errorf("ロックファイルを開けません: %w", err))
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("別のfrpmが実行中です（ロック: %s）", pm.lockPath)
+		}
+		return nil, fmt.Errorf("ロックの取得に失敗: %v", err)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -1,46 +1,96 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"syscall"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type PackageManager struct {
 	db          *sql.DB
 	buildDir    string
 	installRoot string
+	lockPath    string // 変更を伴う操作で排他ロックを取るファイル。空ならロックしない
+	arch        string // インストール先のアーキテクチャ。既定はhostArch()
+	client      *http.Client
+	sandbox     ScriptSandbox
+	download    DownloadPolicy
+	durability  Durability
+	clock       Clock
+	config      Config // 設定ファイルの内容
 }
 
 type Package struct {
 	Name        string
+	Epoch       string // 空なら0
 	Version     string
 	Release     string
 	Arch        string
+	Archs       []string // PKGBUILDのarch配列
 	Source      []string
+	SumAlgo     string // sourceの検証に使う*sums配列の種類（sha256など）。なければ空
+	Checksums   []string
 	Depends     []string
 	MakeDepends []string
+	Provides    []string // 仮想パッケージ名。"name=ver" でバージョン付きにできる
 	PrepareCmd  string
 	BuildCmd    string
 	PackageCmd  string
+	PreInstall  string // installスクリプトのpre_install()。なければ空
+	PostInstall string // installスクリプトのpost_install()。なければ空
+	PreUpgrade  string // installスクリプトのpre_upgrade()。なければ空
+	PostUpgrade string // installスクリプトのpost_upgrade()。なければ空
+	Metadata    map[string]string
+	Size        int64 // インストールされたファイルの合計バイト数
+	Files       []InstalledFile
+	Unverified  int // チェックサムで検証されなかったソースの数
+	InstalledAt time.Time
+	Origin      string // PKGBUILDのパス、またはインポート元
+	Reason      string // ReasonExplicit、ReasonDependency、ReasonImported のいずれか
+	ReasonNote  string // install --reason で指定された任意の理由
+	Held        bool   // holdされていればinstallで置き換えない
 }
 
+// パッケージがインストールされた理由
+const (
+	ReasonExplicit   = "explicit"   // installで明示的にインストールした
+	ReasonDependency = "dependency" // 他のパッケージの依存関係として入った（import --auto）
+	ReasonImported   = "imported"   // 他のパッケージマネージャーの一覧から登録した
+)
+
+var reasonLabels = map[string]string{
+	ReasonExplicit:   "明示的にインストール",
+	ReasonDependency: "依存関係として",
+	ReasonImported:   "インポート",
+}
+
+// metadataVars はMetadataとして保存するPKGBUILDの変数。配列は空白区切りで連結する
+var metadataVars = []string{"pkgdesc", "url", "license", "groups"}
+
 func NewPackageManager(dbPath, buildDir, installRoot string) (*PackageManager, error) {
 	dbDir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		return nil, fmt.Errorf("DBディレクトリの作成に失敗: %v", err)
+		return nil, fmt.Errorf("DBディレクトリの作成に失敗: %w", err)
 	}
 
 	if err := os.MkdirAll(buildDir, 0755); err != nil {
-		return nil, fmt.Errorf("ビルドディレクトリの作成に失敗: %v", err)
+		return nil, fmt.Errorf("ビルドディレクトリの作成に失敗: %w", err)
 	}
 
 	db, err := sql.Open("sqlite3", dbPath)
@@ -52,7 +102,13 @@ func NewPackageManager(dbPath, buildDir, installRoot string) (*PackageManager, e
 		db:          db,
 		buildDir:    buildDir,
 		installRoot: installRoot,
+		lockPath:    filepath.Join(dbDir, "db.lck"),
+		arch:        hostArch(),
+		download:    defaultDownloadPolicy,
+		durability:  DurabilityPerPackage,
+		clock:       wallClock{},
 	}
+	pm.client = newHTTPClient(&pm.download)
 
 	if err := pm.initDB(); err != nil {
 		return nil, err
@@ -61,6 +117,73 @@ func NewPackageManager(dbPath, buildDir, installRoot string) (*PackageManager, e
 	return pm, nil
 }
 
+// OpenReadOnly は既存のDBを読み取り専用で開く。ディレクトリの作成やスキーマの更新は行わないので
+// 書き込み権限のないユーザーでも参照系のコマンドを実行できる。DBの形式が古ければエラーを返す
+func OpenReadOnly(dbPath, installRoot string) (*PackageManager, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, fmt.Errorf("パッケージデータベースを開けません: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+
+	pm := &PackageManager{
+		db:          db,
+		installRoot: installRoot,
+		arch:        hostArch(),
+		download:    defaultDownloadPolicy,
+		durability:  DurabilityPerPackage,
+		clock:       wallClock{},
+	}
+	pm.client = newHTTPClient(&pm.download)
+
+	if err := pm.checkSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return pm, nil
+}
+
+// isPermissionError はディレクトリやDBへの書き込み権限がないことによるエラーか判定する
+func isPermissionError(err error) bool {
+	if errors.Is(err, fs.ErrPermission) {
+		return true
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code {
+		case sqlite3.ErrReadonly, sqlite3.ErrCantOpen, sqlite3.ErrPerm:
+			return true
+		}
+	}
+	return false
+}
+
+// permissionError は権限不足によるエラーを、rootでの実行を促すエラーに置き換える。
+// それ以外のエラーはそのまま返す
+func permissionError(err error) error {
+	if err != nil && isPermissionError(err) {
+		return fmt.Errorf("権限がありません。root で実行してください（%w）", err)
+	}
+	return err
+}
+
+// readOnlyCommands はDBを変更しないコマンド
+var readOnlyCommands = map[string]bool{
+	"list":   true,
+	"info":   true,
+	"files":  true,
+	"owns":   true,
+	"deps":   true,
+	"graph":  true,
+	"verify": true,
+	"search": true,
+	"status": true,
+}
+
 func (pm *PackageManager) initDB() error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS packages (
@@ -85,8 +208,114 @@ func (pm *PackageManager) initDB() error {
 		depends_on TEXT NOT NULL,
 		FOREIGN KEY (package_name) REFERENCES packages(name)
 	);
+	
+	CREATE TABLE IF NOT EXISTS provides (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		package_name TEXT NOT NULL,
+		provides TEXT NOT NULL,
+		FOREIGN KEY (package_name) REFERENCES packages(name)
+	);
+	
+	CREATE TABLE IF NOT EXISTS freeze (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		reason TEXT,
+		frozen_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP
+	);
+	
+	CREATE TABLE IF NOT EXISTS files (
+		package_name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		mtime INTEGER NOT NULL,
+		sha256 TEXT NOT NULL,
+		PRIMARY KEY (package_name, path),
+		FOREIGN KEY (package_name) REFERENCES packages(name)
+	);
 	`
-	_, err := pm.db.Exec(schema)
+	if _, err := pm.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// 既存DBへの列追加
+	for _, c := range packageColumns {
+		if err := pm.addColumnIfMissing("packages", c.name, c.def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packageColumns はpackagesテーブルに後から追加した列。initDBが既存DBに追加する
+var packageColumns = []struct{ name, def string }{
+	{"metadata", "TEXT"},
+	{"size", "INTEGER DEFAULT 0"},
+	{"unverified", "INTEGER DEFAULT 0"},
+	{"epoch", "TEXT DEFAULT ''"},
+	{"origin", "TEXT"},
+	{"reason", "TEXT"},
+	{"reason_note", "TEXT"},
+	{"held", "INTEGER DEFAULT 0"},
+}
+
+// schemaTables はinitDBが作るテーブル
+var schemaTables = []string{"packages", "sources", "dependencies", "provides", "freeze", "files"}
+
+// checkSchema はDBがinitDBで更新済みの形式か確かめる。読み取り専用で開いた場合は
+// 更新できないので、古い形式なら列がないというエラーの代わりに更新の方法を示す
+func (pm *PackageManager) checkSchema() error {
+	outdated := fmt.Errorf("パッケージデータベースの形式が古いため読み取り専用では開けません。" +
+		"一度 root で frpm を実行（例: frpm list）してデータベースを更新してください")
+	for _, table := range schemaTables {
+		var name string
+		err := pm.db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name)
+		if err == sql.ErrNoRows {
+			return outdated
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range packageColumns {
+		ok, err := pm.hasColumn("packages", c.name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return outdated
+		}
+	}
+	return nil
+}
+
+func (pm *PackageManager) hasColumn(table, column string) (bool, error) {
+	rows, err := pm.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func (pm *PackageManager) addColumnIfMissing(table, column, def string) error {
+	ok, err := pm.hasColumn(table, column)
+	if err != nil || ok {
+		return err
+	}
+
+	_, err = pm.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
 	return err
 }
 
@@ -103,16 +332,29 @@ func (pm *PackageManager) ParsePKGBUILD(path string) (*Package, error) {
 	pkg.Name = extractSimpleVar(text, "pkgname")
 	pkg.Version = extractSimpleVar(text, "pkgver")
 	pkg.Release = extractSimpleVar(text, "pkgrel")
-	pkg.Arch = extractSimpleVar(text, "arch")
+	pkg.Epoch = extractSimpleVar(text, "epoch")
+	pkg.Archs = extractArrayVar(text, "arch")
 
-	fmt.Printf("デバッグ: pkgname=%s, pkgver=%s, pkgrel=%s\n", pkg.Name, pkg.Version, pkg.Release)
+	debugf("pkgname=%s, pkgver=%s, pkgrel=%s\n", pkg.Name, pkg.Version, pkg.Release)
 
 	// 配列の抽出
 	pkg.Source = extractArrayVar(text, "source")
 	pkg.Depends = extractArrayVar(text, "depends")
 	pkg.MakeDepends = extractArrayVar(text, "makedepends")
-
-	fmt.Printf("デバッグ: source数=%d, depends数=%d\n", len(pkg.Source), len(pkg.Depends))
+	pkg.Provides = extractArrayVar(text, "provides")
+	pkg.SumAlgo, pkg.Checksums = parseChecksums(text)
+
+	debugf("source数=%d, depends数=%d\n", len(pkg.Source), len(pkg.Depends))
+
+	// メタデータの抽出
+	pkg.Metadata = map[string]string{}
+	for _, v := range metadataVars {
+		if items := extractArrayVar(text, v); len(items) > 0 {
+			pkg.Metadata[v] = strings.Join(items, " ")
+		} else if val := extractSimpleVar(text, v); val != "" {
+			pkg.Metadata[v] = val
+		}
+	}
 
 	// 関数の抽出
 	pkg.PrepareCmd = extractBashFunction(text, "prepare")
@@ -120,22 +362,44 @@ func (pm *PackageManager) ParsePKGBUILD(path string) (*Package, error) {
 	pkg.PackageCmd = extractBashFunction(text, "package")
 
 	if pkg.PrepareCmd != "" {
-		fmt.Printf("デバッグ: prepare関数が見つかりました（%d文字）\n", len(pkg.PrepareCmd))
+		debugf("prepare関数が見つかりました（%d文字）\n", len(pkg.PrepareCmd))
 	}
 	if pkg.BuildCmd != "" {
-		fmt.Printf("デバッグ: build関数が見つかりました（%d文字）\n", len(pkg.BuildCmd))
+		debugf("build関数が見つかりました（%d文字）\n", len(pkg.BuildCmd))
 	}
 	if pkg.PackageCmd != "" {
-		fmt.Printf("デバッグ: package関数が見つかりました（%d文字）\n", len(pkg.PackageCmd))
+		debugf("package関数が見つかりました（%d文字）\n", len(pkg.PackageCmd))
+	}
+	if name := extractSimpleVar(text, "install"); name != "" {
+		if err := loadInstallScript(pkg, path, name); err != nil {
+			return nil, err
+		}
 	}
 
 	if pkg.Name == "" {
 		return nil, fmt.Errorf("pkgnameが見つかりません")
 	}
 
+	pkg.Arch, err = selectArch(pkg.Archs, pm.arch)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", pkg.Name, err)
+	}
+
 	return pkg, nil
 }
 
+// debugEnabled は環境変数FRPM_DEBUGが設定されているときだけtrueになる
+var debugEnabled = os.Getenv("FRPM_DEBUG") != ""
+
+// debugf はFRPM_DEBUGが設定されていればデバッグ出力を標準エラーに書く。
+// 標準出力はJSONなどの結果のために空けておく
+func debugf(format string, args ...interface{}) {
+	if !debugEnabled {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "デバッグ: "+format, args...)
+}
+
 func extractSimpleVar(content, varName string) string {
 	re := regexp.MustCompile(`(?m)^\s*` + varName + `=([^\n]+)`)
 	matches := re.FindStringSubmatch(content)
@@ -190,7 +454,7 @@ func extractBashFunction(content, funcName string) string {
 		body := matches[1]
 		// 最初と最後の空行を削除
 		body = strings.TrimSpace(body)
-		fmt.Printf("デバッグ: %s()関数を抽出しました（パターン1、%d文字）\n", funcName, len(body))
+		debugf("%s()関数を抽出しました（パターン1、%d文字）\n", funcName, len(body))
 		return body
 	}
 
@@ -200,7 +464,7 @@ func extractBashFunction(content, funcName string) string {
 	if len(matches) >= 2 {
 		body := matches[1]
 		body = strings.TrimSpace(body)
-		fmt.Printf("デバッグ: %s()関数を抽出しました（パターン2、%d文字）\n", funcName, len(body))
+		debugf("%s()関数を抽出しました（パターン2、%d文字）\n", funcName, len(body))
 		return body
 	}
 
@@ -217,7 +481,7 @@ func extractBashFunction(content, funcName string) string {
 		if !inFunction && (trimmed == funcName+"() {" || strings.HasPrefix(trimmed, funcName+"()")) {
 			inFunction = true
 			braceCount = strings.Count(line, "{") - strings.Count(line, "}")
-			fmt.Printf("デバッグ: %s()関数の開始を検出（行%d）\n", funcName, i+1)
+			debugf("%s()関数の開始を検出（行%d）\n", funcName, i+1)
 			continue
 		}
 		
@@ -228,7 +492,7 @@ func extractBashFunction(content, funcName string) string {
 				// 関数の終了
 				body := strings.Join(functionBody, "\n")
 				body = strings.TrimSpace(body)
-				fmt.Printf("デバッグ: %s()関数を抽出しました（パターン3、%d文字、%d行）\n", funcName, len(body), len(functionBody))
+				debugf("%s()関数を抽出しました（パターン3、%d文字、%d行）\n", funcName, len(body), len(functionBody))
 				return body
 			}
 			
@@ -236,19 +500,79 @@ func extractBashFunction(content, funcName string) string {
 		}
 	}
 	
-	fmt.Printf("デバッグ: %s()関数が見つかりませんでした\n", funcName)
+	debugf("%s()関数が見つかりませんでした\n", funcName)
 	return ""
 }
 
-func (pm *PackageManager) Install(pkgbuildPath string) error {
+// InstallOptions はInstallの動作を変更するオプション
+type InstallOptions struct {
+	OnlyUpgrade      bool   // インストール済みのパッケージだけを更新し、未インストールならスキップする
+	RequireChecksums bool   // チェックサムで検証できないソースがあればインストールしない
+	DryRun           bool   // 何も変更せず計画だけを表示する
+	Reason           string // 記録しておくインストールの理由（任意）
+
+	batch *installBatch // InstallAllから呼ばれた場合に変更を記録する
+}
+
+func (pm *PackageManager) Install(pkgbuildPath string, opts InstallOptions) error {
+	if opts.DryRun {
+		return pm.printPlan([]string{pkgbuildPath}, opts)
+	}
+
+	unlock, err := pm.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return pm.install(pkgbuildPath, opts)
+}
+
+// install はInstallの本体。呼び出し側がロックを取得している必要がある
+func (pm *PackageManager) install(pkgbuildPath string, opts InstallOptions) error {
+	if err := pm.checkFrozen(); err != nil {
+		return err
+	}
+
 	pkg, err := pm.ParsePKGBUILD(pkgbuildPath)
 	if err != nil {
 		return fmt.Errorf("PKGBUILDの解析に失敗: %v", err)
 	}
+	pkg.Reason, pkg.ReasonNote = ReasonExplicit, opts.Reason
+	if pkg.Origin, err = filepath.Abs(pkgbuildPath); err != nil {
+		pkg.Origin = pkgbuildPath
+	}
 
-	fmt.Printf("パッケージをインストール: %s-%s-%s\n", pkg.Name, pkg.Version, pkg.Release)
+	if opts.OnlyUpgrade && !pm.isInstalled(pkg.Name) {
+		fmt.Printf("パッケージ %s はインストールされていないためスキップします（--only-upgrade）\n", pkg.Name)
+		return nil
+	}
+
+	fmt.Printf("パッケージをインストール: %s-%s\n", pkg.Name, pkg.FullVersion())
+	oldVersion := "" // 置き換えるバージョン。新規インストールなら空
+	installed, ok, err := pm.GetInstalled(pkg.Name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		oldVersion = installed.FullVersion()
+		if err := checkHeld(installed); err != nil {
+			return err
+		}
+		switch CompareVersions(pkg.FullVersion(), installed.FullVersion()) {
+		case 1:
+			fmt.Printf("アップグレード: %s → %s\n", installed.FullVersion(), pkg.FullVersion())
+		case -1:
+			fmt.Printf("警告: ダウングレードします: %s → %s\n", installed.FullVersion(), pkg.FullVersion())
+		default:
+			fmt.Printf("同じバージョン %s を再インストールします\n", installed.FullVersion())
+		}
+	}
 	fmt.Printf("依存関係: %v\n", pkg.Depends)
 	fmt.Printf("ビルド依存: %v\n", pkg.MakeDepends)
+	if err := pm.checkDependencies(pkg); err != nil {
+		return err
+	}
 
 	// ビルドディレクトリ作成
 	pkgBuildDir := filepath.Join(pm.buildDir, pkg.Name)
@@ -274,23 +598,20 @@ func (pm *PackageManager) Install(pkgbuildPath string) error {
 
 	// ソースダウンロード
 	fmt.Println("\n==> ソースを取得中...")
-	for _, src := range pkg.Source {
-		// URLでない場合はスキップ（ローカルファイルとして扱う）
-		if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
-			continue
-		}
-		
-		// {,.asc} のような表記を展開
-		if strings.Contains(src, "{") {
-			baseSrc := strings.Split(src, "{")[0]
-			if err := pm.downloadSource(baseSrc, pkgBuildDir); err != nil {
-				fmt.Printf("警告: %sのダウンロードに失敗: %v\n", baseSrc, err)
-			}
-		} else {
-			if err := pm.downloadSource(src, pkgBuildDir); err != nil {
-				fmt.Printf("警告: %sのダウンロードに失敗: %v\n", src, err)
-			}
+	if err := pm.fetchSources(downloadSources(pkg), pkgBuildDir); err != nil {
+		return err
+	}
+
+	fmt.Println("\n==> チェックサムを検証中...")
+	pkg.Unverified, err = verifySources(pkg, pkgBuildDir)
+	if err != nil {
+		return fmt.Errorf("ソースの検証に失敗: %v", err)
+	}
+	if pkg.Unverified > 0 {
+		if opts.RequireChecksums {
+			return fmt.Errorf("%d 個のソースがチェックサムで検証できません（--require-checksums）", pkg.Unverified)
 		}
+		fmt.Printf("警告: %d 個のソースはチェックサムで検証されていません\n", pkg.Unverified)
 	}
 
 	// prepare実行
@@ -323,18 +644,58 @@ func (pm *PackageManager) Install(pkgbuildPath string) error {
 		fmt.Println("\n==> package()関数なし、スキップ")
 	}
 
+	// 置き換え前のファイルの記録。古いバージョンにしかないファイルを後で削除する
+	oldFiles, err := pm.packageFiles(pkg.Name)
+	if err != nil {
+		return err
+	}
+
+	// pre_install/pre_upgradeが失敗したら何もインストールせずに中止する
+	preHook, postHook := installHooks(pkg, oldVersion != "")
+	if preHook.Body != "" {
+		fmt.Printf("\n==> %s()を実行中...\n", preHook.Name)
+		if err := pm.runHook(preHook, pkg, oldVersion); err != nil {
+			return fmt.Errorf("%sに失敗: %v", preHook.Name, err)
+		}
+	}
+
+	// 失敗したときに元に戻せるよう、置き換える前のファイルを退避しておく
+	if opts.batch != nil {
+		if err := opts.batch.record(pm, pkg, installed); err != nil {
+			return err
+		}
+	}
+
 	// pkgdirの内容をインストール
 	pkgDir := filepath.Join(pkgBuildDir, "pkg")
 	if _, err := os.Stat(pkgDir); err == nil {
 		fmt.Println("\n==> ファイルをインストール中...")
-		if err := pm.installFiles(pkgDir); err != nil {
-			return fmt.Errorf("ファイルのインストールに失敗: %v", err)
+		pkg.Files, err = pm.installFiles(pkgDir)
+		if err != nil {
+			return fmt.Errorf("ファイルのインストールに失敗: %v", permissionError(err))
 		}
+		for _, f := range pkg.Files {
+			pkg.Size += f.Size
+		}
+		fmt.Printf("インストールサイズ: %s\n", humanizeBytes(pkg.Size))
 	}
 
 	// DBに登録
 	if err := pm.registerPackage(pkg); err != nil {
-		return fmt.Errorf("パッケージの登録に失敗: %v", err)
+		return fmt.Errorf("パッケージの登録に失敗: %v", permissionError(err))
+	}
+
+	// 新しいバージョンに含まれなくなったファイルを片付ける
+	if err := pm.removeStaleFiles(pkg.Name, oldFiles, pkg.Files); err != nil {
+		fmt.Printf("警告: 古いファイルの削除に失敗: %v\n", err)
+	}
+
+	// ファイルは既に登録済みなので、post_install/post_upgradeの失敗は警告に留める
+	if postHook.Body != "" {
+		fmt.Printf("\n==> %s()を実行中...\n", postHook.Name)
+		if err := pm.runHook(postHook, pkg, oldVersion); err != nil {
+			fmt.Printf("警告: %sに失敗: %v\n", postHook.Name, err)
+		}
 	}
 
 	fmt.Printf("\n==> パッケージ %s のインストールが完了しました\n", pkg.Name)
@@ -348,13 +709,14 @@ func (pm *PackageManager) runPhase(phase, cmd, workDir string, pkg *Package) err
 	os.MkdirAll(pkgDir, 0755)
 
 	// makepkgの環境変数を設定
-	env := os.Environ()
+	env := pm.sandbox.env(workDir)
 	env = append(env,
 		fmt.Sprintf("srcdir=%s", srcDir),
 		fmt.Sprintf("pkgdir=%s", pkgDir),
 		fmt.Sprintf("pkgname=%s", pkg.Name),
 		fmt.Sprintf("pkgver=%s", pkg.Version),
 		fmt.Sprintf("pkgrel=%s", pkg.Release),
+		fmt.Sprintf("CARCH=%s", pm.arch),
 	)
 
 	// PKGBUILDをsourceしてから関数を実行
@@ -369,48 +731,117 @@ echo "==> %s()を実行します..."
 %s
 `, workDir, phase, cmd, phase, phase)
 
-	fmt.Printf("デバッグ: 実行するスクリプト:\n%s\n", script)
+	debugf("実行するスクリプト:\n%s\n", script)
 
-	cmdExec := exec.Command("bash", "-c", script)
+	ctx, cancel := pm.sandbox.context()
+	defer cancel()
+
+	cmdExec := exec.CommandContext(ctx, "bash", "-c", script)
+	cmdExec.Dir = workDir
 	cmdExec.Env = env
 	cmdExec.Stdout = os.Stdout
 	cmdExec.Stderr = os.Stderr
+	cmdExec.WaitDelay = 5 * time.Second
 
-	return cmdExec.Run()
+	if err := pm.sandbox.prepare(cmdExec, workDir); err != nil {
+		return err
+	}
+
+	return pm.sandbox.scriptError(ctx, cmdExec.Run())
 }
 
-func (pm *PackageManager) downloadSource(url, destDir string) error {
+// downloadSources はsourceのうちダウンロードが必要なものを展開して返す。
+// "name::url" の形式はそのまま残し、保存先の名前はsourceFilenameで決める
+func downloadSources(pkg *Package) []string {
+	sources := []string{}
+	for _, src := range pkg.Source {
+		for _, s := range expandSource(src) {
+			// URLでない場合はスキップ（ローカルファイルとして扱う）
+			url := sourceURL(s)
+			if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+				continue
+			}
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}
+
+// downloadURLs はsourceのうちダウンロードが必要なURLを返す
+func downloadURLs(pkg *Package) []string {
+	urls := []string{}
+	for _, src := range downloadSources(pkg) {
+		urls = append(urls, sourceURL(src))
+	}
+	return urls
+}
+
+// downloadSource はソース1つをdestDirにsourceFilenameの名前で保存する
+func (pm *PackageManager) downloadSource(src, destDir string) error {
+	url := sourceURL(src)
 	fmt.Printf("  -> ダウンロード中: %s\n", url)
 
-	resp, err := http.Get(url)
+	// 応答やデータの受信がTimeoutの間途絶えたら打ち切る。全体の時間は制限しない
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	idle := time.AfterFunc(pm.download.Timeout, cancel)
+	defer idle.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return &downloadError{Err: err, Permanent: true}
+	}
+	resp, err := pm.client.Do(req)
+	if err != nil {
+		return &downloadError{
+			Err:       timeoutError(ctx, err, pm.download.Timeout),
+			Permanent: errors.Is(err, errRedirectRefused),
+		}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("HTTP %d", resp.StatusCode)
-	}
-
-	filename := filepath.Base(url)
-	if idx := strings.Index(filename, "?"); idx > 0 {
-		filename = filename[:idx]
+		// 4xxはサーバー側の状態が変わらない限り何度試しても同じ
+		return &downloadError{
+			Err:       fmt.Errorf("HTTP %d", resp.StatusCode),
+			Permanent: resp.StatusCode >= 400 && resp.StatusCode < 500,
+		}
 	}
 
-	destPath := filepath.Join(destDir, filename)
+	destPath := filepath.Join(destDir, sourceFilename(src))
 
 	out, err := os.Create(destPath)
 	if err != nil {
-		return err
+		return &downloadError{Err: err, Permanent: true}
 	}
 	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
-	return err
+	var body io.Reader = &idleReader{r: resp.Body, timer: idle, timeout: pm.download.Timeout}
+	// 並行に取得していると進捗の行が混ざるので表示しない
+	if !pm.download.Quiet && pm.download.Concurrency <= 1 {
+		progress := &progressReader{r: body, w: os.Stdout, total: resp.ContentLength}
+		defer progress.finish()
+		body = progress
+	}
+	if _, err := io.Copy(out, body); err != nil {
+		return &downloadError{Err: timeoutError(ctx, err, pm.download.Timeout)}
+	}
+	return nil
 }
 
-func (pm *PackageManager) installFiles(pkgDir string) error {
-	return filepath.Walk(pkgDir, func(path string, info os.FileInfo, err error) error {
+// installFiles はpkgDirの内容をinstallRootにインストールする。
+// 全ファイルを置き換え先の隣に一時ファイルとして書き終えてから順にrenameで置き換えるので、
+// 書き込み中に失敗・クラッシュしても古いバージョンのファイルは欠けずに残る。
+// 置き換えの途中で失敗した場合は、それまでに置き換えたファイルをエラーと共に返す
+func (pm *PackageManager) installFiles(pkgDir string) ([]InstalledFile, error) {
+	staged := []stagedFile{}
+	cleanup := func() {
+		for _, sf := range staged {
+			os.Remove(sf.tmp)
+		}
+	}
+
+	err := filepath.Walk(pkgDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -426,8 +857,56 @@ func (pm *PackageManager) installFiles(pkgDir string) error {
 			return os.MkdirAll(destPath, info.Mode())
 		}
 
-		return copyFile(path, destPath)
+		// renameした後に中身が空にならないよう、置き換える前に同期しておく。
+		// per-packageでは全て書き終えてからまとめて同期する
+		sf, err := stageFile(path, destPath, relPath, pm.durability == DurabilityPerFile)
+		if err != nil {
+			return err
+		}
+		staged = append(staged, sf)
+		return nil
 	})
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	// 書き終えてからまとめて同期するので、ファイルごとに書き込みの完了を待たずに済む
+	if pm.durability == DurabilityPerPackage {
+		for _, sf := range staged {
+			if err := syncPath(sf.tmp); err != nil {
+				cleanup()
+				return nil, err
+			}
+		}
+	}
+
+	files := []InstalledFile{}
+	dirs := map[string]bool{}
+	for i, sf := range staged {
+		if err := os.Rename(sf.tmp, sf.dst); err != nil {
+			cleanup()
+			return files, err
+		}
+		staged[i].tmp = ""
+		files = append(files, sf.InstalledFile)
+		dirs[filepath.Dir(sf.dst)] = true
+
+		if pm.durability == DurabilityPerFile {
+			if err := syncPath(filepath.Dir(sf.dst)); err != nil {
+				return files, err
+			}
+		}
+	}
+
+	if pm.durability == DurabilityPerPackage {
+		for dir := range dirs {
+			if err := syncPath(dir); err != nil {
+				return files, err
+			}
+		}
+	}
+	return files, nil
 }
 
 func copyFile(src, dst string) error {
@@ -460,14 +939,32 @@ func (pm *PackageManager) registerPackage(pkg *Package) error {
 	}
 	defer tx.Rollback()
 
+	metadata, err := json.Marshal(pkg.Metadata)
+	if err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(`
-		INSERT OR REPLACE INTO packages (name, version, release, arch, installed, installed_at)
-		VALUES (?, ?, ?, ?, 1, CURRENT_TIMESTAMP)
-	`, pkg.Name, pkg.Version, pkg.Release, pkg.Arch)
+		INSERT OR REPLACE INTO packages (name, epoch, version, release, arch, installed, installed_at, metadata, size, unverified,
+			origin, reason, reason_note)
+		VALUES (?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?)
+	`, pkg.Name, pkg.Epoch, pkg.Version, pkg.Release, pkg.Arch, pm.now(), string(metadata), pkg.Size, pkg.Unverified,
+		pkg.Origin, pkg.Reason, pkg.ReasonNote)
 	if err != nil {
 		return err
 	}
 
+	// 再インストール時に古いバージョンの情報が残らないようにする
+	for _, table := range []string{"sources", "dependencies", "provides", "files"} {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE package_name = ?", table), pkg.Name); err != nil {
+			return err
+		}
+	}
+
+	if err := insertFiles(tx, pkg.Name, pkg.Files); err != nil {
+		return err
+	}
+
 	for _, src := range pkg.Source {
 		_, err = tx.Exec(`
 			INSERT INTO sources (package_name, url) VALUES (?, ?)
@@ -486,6 +983,15 @@ func (pm *PackageManager) registerPackage(pkg *Package) error {
 		}
 	}
 
+	for _, prov := range pkg.Provides {
+		_, err = tx.Exec(`
+			INSERT INTO provides (package_name, provides) VALUES (?, ?)
+		`, pkg.Name, prov)
+		if err != nil {
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
 
@@ -497,75 +1003,234 @@ func (pm *PackageManager) isInstalled(pkgName string) bool {
 	return err == nil && installed == 1
 }
 
-func (pm *PackageManager) ListInstalled() error {
-	rows, err := pm.db.Query(`
-		SELECT name, version, release, installed_at 
-		FROM packages 
-		WHERE installed = 1
-		ORDER BY name
-	`)
+// installedColumns はインストール済みパッケージとして読み出すpackagesの列。scanInstalledと順番を合わせる
+const installedColumns = `name, epoch, version, release, arch, installed_at, metadata, size, unverified,
+	origin, reason, reason_note, held`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanInstalled(row rowScanner) (*Package, error) {
+	pkg := &Package{}
+	var epoch, metadataJSON, origin, reason, reasonNote sql.NullString
+	err := row.Scan(&pkg.Name, &epoch, &pkg.Version, &pkg.Release, &pkg.Arch,
+		&pkg.InstalledAt, &metadataJSON, &pkg.Size, &pkg.Unverified,
+		&origin, &reason, &reasonNote, &pkg.Held)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	pkg.Epoch, pkg.Origin, pkg.Reason, pkg.ReasonNote = epoch.String, origin.String, reason.String, reasonNote.String
+	if pkg.Metadata, err = parseMetadata(metadataJSON); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// GetInstalled はインストール済みパッケージの記録をソース・依存関係・ファイルも含めて返す。
+// インストールされていなければfalseを返す
+func (pm *PackageManager) GetInstalled(pkgName string) (*Package, bool, error) {
+	pkg, err := scanInstalled(pm.db.QueryRow(`
+		SELECT `+installedColumns+` FROM packages WHERE name = ? AND installed = 1
+	`, pkgName))
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if pkg.Source, err = pm.packageColumn("sources", "url", pkgName); err != nil {
+		return nil, false, err
+	}
+	if pkg.Depends, err = pm.packageColumn("dependencies", "depends_on", pkgName); err != nil {
+		return nil, false, err
+	}
+	if pkg.Provides, err = pm.packageColumn("provides", "provides", pkgName); err != nil {
+		return nil, false, err
+	}
+	if pkg.Files, err = pm.packageFiles(pkgName); err != nil {
+		return nil, false, err
+	}
+	return pkg, true, nil
+}
+
+// packageColumn はパッケージに紐づくtableの行からcolumnを登録順に集める
+func (pm *PackageManager) packageColumn(table, column, pkgName string) ([]string, error) {
+	rows, err := pm.db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE package_name = ? ORDER BY id", column, table), pkgName)
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
 
-	fmt.Println("インストール済みパッケージ:")
-	fmt.Println("----------------------------------------")
-	count := 0
+	values := []string{}
 	for rows.Next() {
-		var name, version, release, installedAt string
-		if err := rows.Scan(&name, &version, &release, &installedAt); err != nil {
-			return err
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
 		}
-		fmt.Printf("%s %s-%s (インストール日時: %s)\n", name, version, release, installedAt)
-		count++
+		values = append(values, v)
 	}
+	return values, rows.Err()
+}
 
-	if count == 0 {
-		fmt.Println("(なし)")
+// ListOptions はListInstalledの絞り込みと表示のオプション
+type ListOptions struct {
+	NewerThan time.Time // ゼロ値でなければこれ以降にインストールされたものだけ表示
+	Bytes     bool      // サイズをバイト数のまま表示する
+	Wide      bool      // インストール理由とインストール元も表示する
+	JSON      bool      // ListEntryの配列としてJSONで出力する
+	Group     string    // 空でなければこのグループに属するものだけ表示
+}
+
+// ListEntry は list --json で出力するインストール済みパッケージ1件分
+type ListEntry struct {
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	Arch        string    `json:"arch"`
+	Size        int64     `json:"size"`
+	InstalledAt time.Time `json:"installed_at"`
+	Reason      string    `json:"reason,omitempty"`
+	Origin      string    `json:"origin,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Held        bool      `json:"held"`
+	Groups      []string  `json:"groups,omitempty"`
+}
+
+func (pm *PackageManager) ListInstalled(w io.Writer, opts ListOptions) error {
+	pkgs, err := pm.installedPackages()
+	if err != nil {
+		return err
+	}
+
+	entries := []ListEntry{}
+	for _, pkg := range pkgs {
+		if !opts.NewerThan.IsZero() && pkg.InstalledAt.Before(opts.NewerThan) {
+			continue
+		}
+		if opts.Group != "" && !matchesMetadata(pkg, metaFilters{"groups": opts.Group}) {
+			continue
+		}
+		entries = append(entries, ListEntry{
+			Name:        pkg.Name,
+			Version:     pkg.FullVersion(),
+			Arch:        pkg.Arch,
+			Size:        pkg.Size,
+			InstalledAt: pkg.InstalledAt,
+			Reason:      pkg.Reason,
+			Origin:      pkg.Origin,
+			Description: pkg.Metadata["pkgdesc"],
+			Held:        pkg.Held,
+			Groups:      strings.Fields(pkg.Metadata["groups"]),
+		})
 	}
 
-	return rows.Err()
+	if opts.JSON {
+		return writeJSON(w, entries)
+	}
+
+	fmt.Fprintln(w, "インストール済みパッケージ:")
+	fmt.Fprintln(w, "----------------------------------------")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s %s %s (インストール日時: %s)", e.Name, e.Version, formatSize(e.Size, opts.Bytes), formatTime(e.InstalledAt))
+		if opts.Wide {
+			fmt.Fprintf(w, " %s %s", orDash(e.Reason), orDash(e.Origin))
+		}
+		fmt.Fprintln(w)
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "(なし)")
+	}
+
+	return nil
 }
 
-func (pm *PackageManager) Info(pkgName string) error {
-	var name, version, release, arch, installedAt string
-	err := pm.db.QueryRow(`
-		SELECT name, version, release, arch, installed_at 
-		FROM packages 
-		WHERE name = ?
-	`, pkgName).Scan(&name, &version, &release, &arch, &installedAt)
+// writeJSON はvを字下げしたJSONで書き出す。依存関係の ">=" などはエスケープしない
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
 
-	if err == sql.ErrNoRows {
-		fmt.Printf("パッケージ %s はインストールされていません\n", pkgName)
-		return nil
+// orDash は空文字列を表の列として表示するために "-" に置き換える
+func orDash(s string) string {
+	if s == "" {
+		return "-"
 	}
+	return s
+}
+
+// InfoOptions はInfoの表示オプション。既定では概要だけを表示する
+type InfoOptions struct {
+	Bytes bool // サイズをバイト数のまま表示する
+	Deps  bool // 依存関係をツリーで表示する
+	Files bool // インストールしたファイルの一覧を表示する
+}
+
+func (pm *PackageManager) Info(pkgName string, opts InfoOptions) error {
+	pkg, ok, err := pm.GetInstalled(pkgName)
 	if err != nil {
 		return err
 	}
+	if !ok {
+		fmt.Printf("パッケージ %s はインストールされていません\n", pkgName)
+		return nil
+	}
 
-	fmt.Printf("パッケージ名: %s\n", name)
-	fmt.Printf("バージョン: %s-%s\n", version, release)
-	fmt.Printf("アーキテクチャ: %s\n", arch)
-	fmt.Printf("インストール日時: %s\n", installedAt)
+	fmt.Printf("パッケージ名: %s\n", pkg.Name)
+	fmt.Printf("バージョン: %s\n", pkg.FullVersion())
+	fmt.Printf("アーキテクチャ: %s\n", pkg.Arch)
+	fmt.Printf("インストールサイズ: %s\n", formatSize(pkg.Size, opts.Bytes))
+	fmt.Printf("インストール日時: %s\n", formatTime(pkg.InstalledAt))
+	if pkg.Unverified > 0 {
+		fmt.Printf("未検証: %d 個のソースがチェックサムで検証されていません\n", pkg.Unverified)
+	}
+	if pkg.Held {
+		fmt.Println("保持: はい（unholdするまで更新されません）")
+	}
+	if pkg.Reason != "" {
+		reason := reasonLabels[pkg.Reason]
+		if reason == "" {
+			reason = pkg.Reason
+		}
+		if pkg.ReasonNote != "" {
+			reason += "（" + pkg.ReasonNote + "）"
+		}
+		fmt.Printf("インストール理由: %s\n", reason)
+	}
+	if pkg.Origin != "" {
+		fmt.Printf("インストール元: %s\n", pkg.Origin)
+	}
+
+	printMetadata(pkg.Metadata)
 
 	// 依存関係
-	rows, err := pm.db.Query(`
-		SELECT depends_on FROM dependencies WHERE package_name = ?
-	`, pkgName)
-	if err == nil {
-		defer rows.Close()
-		fmt.Print("依存関係: ")
-		deps := []string{}
-		for rows.Next() {
-			var dep string
-			rows.Scan(&dep)
-			deps = append(deps, dep)
-		}
-		if len(deps) > 0 {
-			fmt.Println(strings.Join(deps, ", "))
-		} else {
-			fmt.Println("(なし)")
+	fmt.Print("依存関係: ")
+	if len(pkg.Depends) > 0 {
+		fmt.Println(strings.Join(pkg.Depends, ", "))
+	} else {
+		fmt.Println("(なし)")
+	}
+	if len(pkg.Provides) > 0 {
+		fmt.Printf("提供: %s\n", strings.Join(pkg.Provides, ", "))
+	}
+
+	if opts.Deps {
+		graph, err := pm.dependencyGraph()
+		if err != nil {
+			return err
+		}
+		fmt.Println("\n依存関係ツリー:")
+		fmt.Println(pkg.Name)
+		printTree(graph, pkg.Name, "", map[string]bool{pkg.Name: true})
+	}
+
+	if opts.Files {
+		fmt.Printf("\nファイル（%d 個）:\n", len(pkg.Files))
+		for _, f := range pkg.Files {
+			fmt.Printf("  %s %s\n", filepath.Join(pm.installRoot, f.Path), formatSize(f.Size, opts.Bytes))
 		}
 	}
 
@@ -576,54 +1241,411 @@ func (pm *PackageManager) Close() error {
 	return pm.db.Close()
 }
 
+func usage() {
+	fmt.Println("使用方法: [--root <DIR>] [--json] <コマンド> [引数]")
+	fmt.Println("  install [--sandbox] [--sandbox-user <USER>] [--timeout <期間>]")
+	fmt.Println("          [--on-download-error abort|continue] [--download-attempts <N>] [--download-timeout <期間>]")
+	fmt.Println("          [--allow-cross-host-redirect] [--proxy <URL>] [--quiet]")
+	fmt.Println("          [--only-upgrade] [--require-checksums] [--arch <ARCH>] [--reason <理由>]")
+	fmt.Println("          [--durability none|per-package|per-file] [--dry-run [--json]] [--yes|-y] <PKGBUILD_PATH>...")
+	fmt.Println("                          - パッケージをインストール")
+	fmt.Println("  list [--newer-than <日時|期間>] [--group <GROUP>] [--bytes] [--wide] [--json]")
+	fmt.Println("                          - インストール済みパッケージを表示")
+	fmt.Println("  info [--bytes] [--deps] [--files] <PKG_NAME>")
+	fmt.Println("                          - パッケージ情報を表示")
+	fmt.Println("  files <PKG_NAME>        - パッケージがインストールしたファイルを表示")
+	fmt.Println("  owns <PATH>             - ファイルを所有するパッケージを表示")
+	fmt.Println("  hold <PKG_NAME> | hold --list")
+	fmt.Println("                          - パッケージを保持して更新を禁止（--listで一覧）")
+	fmt.Println("  unhold <PKG_NAME>       - パッケージの保持を解除")
+	fmt.Println("  deps [--reverse] [--flat] <PKG_NAME>")
+	fmt.Println("                          - 依存関係（--reverseで逆依存）をツリー表示")
+	fmt.Println("  graph [PKG_NAME]        - 依存関係をGraphViz DOT形式で出力")
+	fmt.Println("  verify [--quick|--thorough] [--all] [PKG_NAME]")
+	fmt.Println("                          - インストール済みファイルを検証（省略時は全て）")
+	fmt.Println("  search [--meta <KEY=VALUE>] [--description-only] [--exact] [--regex|--regex-desc] [--json] [QUERY]")
+	fmt.Println("                          - インストール済みパッケージを検索")
+	fmt.Println("                            （name:<語> や desc:<語> で対象を限定）")
+	fmt.Println("  import [--explicit|--auto] --from pacman|dpkg|rpm | <FILE|->")
+	fmt.Println("                          - 他のパッケージマネージャーの一覧を登録")
+	fmt.Println("  freeze [--until <日時|期間>] [理由]")
+	fmt.Println("                          - システムを凍結し変更を禁止")
+	fmt.Println("  thaw                    - システムの凍結を解除")
+	fmt.Println("  status                  - 凍結状態を表示")
+	fmt.Println("  version, --version      - バージョン情報を表示")
+	fmt.Println("グローバルオプション（コマンドの前に指定）:")
+	fmt.Println("  --root <DIR>            - ホームディレクトリの代わりにDIRの下にDB・ビルド・インストール先を置く")
+	fmt.Println("                            （環境変数FRPM_ROOTでも指定可、フラグが優先）")
+	fmt.Println("  --json                  - search と list の結果をJSONで出力")
+	fmt.Println("設定ファイル: <ホームまたはDIR>/.config/gopkg/config.json（なければ既定値で作成）")
+	fmt.Println("環境変数FRPM_DEBUGを設定するとデバッグ出力を標準エラーに表示")
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("使用方法:")
-		fmt.Println("  install <PKGBUILD_PATH> - パッケージをインストール")
-		fmt.Println("  list                    - インストール済みパッケージを表示")
-		fmt.Println("  info <PKG_NAME>         - パッケージ情報を表示")
+	gfs := flag.NewFlagSet("frpm", flag.ExitOnError)
+	gfs.Usage = usage
+	rootDir := gfs.String("root", os.Getenv("FRPM_ROOT"), "DB・ビルド・インストール先の基準ディレクトリ")
+	showVersion := gfs.Bool("version", false, "バージョン情報を表示")
+	globalJSON := gfs.Bool("json", false, "search と list の結果をJSONで出力")
+	gfs.Parse(os.Args[1:])
+	args := gfs.Args()
+
+	// バージョン表示はDBを開かずに行う
+	if *showVersion || (len(args) > 0 && args[0] == "version") {
+		printVersion(os.Stdout)
+		return
+	}
+	if len(args) < 1 {
+		usage()
 		os.Exit(1)
 	}
 
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ホームディレクトリの取得エラー: %v\n", err)
+	// DBなどの場所は全てbaseDirから決める。--rootがなければホームディレクトリ
+	baseDir := *rootDir
+	var err error
+	if baseDir == "" {
+		baseDir, err = os.UserHomeDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ホームディレクトリの取得エラー: %v\n", err)
+			os.Exit(1)
+		}
+	} else if err := os.MkdirAll(baseDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "ルートディレクトリを作成できません: %v\n", err)
 		os.Exit(1)
 	}
 
-	pm, err := NewPackageManager(
-		filepath.Join(homeDir, ".local/share/gopkg/packages.db"),
-		filepath.Join(homeDir, ".cache/gopkg-build"),
-		filepath.Join(homeDir, ".local"),
-	)
+	cmd := args[0]
+	dbPath := filepath.Join(baseDir, ".local/share/gopkg/packages.db")
+	installRoot := filepath.Join(baseDir, ".local")
+
+	pm, err := NewPackageManager(dbPath, filepath.Join(baseDir, ".cache/gopkg-build"), installRoot)
+	if err != nil && isPermissionError(err) {
+		if readOnlyCommands[cmd] {
+			pm, err = OpenReadOnly(dbPath, installRoot)
+		} else {
+			err = permissionError(err)
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "初期化エラー: %v\n", err)
 		os.Exit(1)
 	}
 	defer pm.Close()
 
-	cmd := os.Args[1]
+	cfg, err := loadConfig(filepath.Join(baseDir, ".config/gopkg/config.json"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "初期化エラー: %v\n", err)
+		os.Exit(1)
+	}
+	pm.applyConfig(cfg)
+
+	if arch := os.Getenv("FRPM_ARCH"); arch != "" {
+		pm.arch = arch
+	}
+
 	switch cmd {
 	case "install":
-		if len(os.Args) < 3 {
+		fs := flag.NewFlagSet("install", flag.ExitOnError)
+		fs.BoolVar(&pm.sandbox.Enabled, "sandbox", false, "環境変数を整理しビルドディレクトリに閉じ込めてPKGBUILDの関数を実行")
+		fs.StringVar(&pm.sandbox.User, "sandbox-user", "", "サンドボックス内でPKGBUILDの関数を実行するユーザー（--sandboxを含意）")
+		timeout := fs.String("timeout", "", "PKGBUILDの各関数の実行時間の上限（例: 30m, 2h）")
+		fs.StringVar(&pm.download.OnError, "on-download-error", pm.download.OnError, "ソースの取得に失敗した場合の動作（abort: 中止, continue: 残りを取得して最後に報告し中止）")
+		fs.IntVar(&pm.download.Attempts, "download-attempts", pm.download.Attempts, "一時的な失敗に対するダウンロードの試行回数")
+		fs.BoolVar(&pm.download.CrossHost, "allow-cross-host-redirect", false, "ソースのダウンロードで別のホストへのリダイレクトを許可する")
+		fs.DurationVar(&pm.download.Timeout, "download-timeout", pm.download.Timeout, "応答やデータの受信が途絶えてからダウンロードを打ち切るまでの時間")
+		fs.BoolVar(&pm.download.Quiet, "quiet", false, "ダウンロードの進捗を表示しない")
+		fs.StringVar(&pm.download.Proxy, "proxy", pm.download.Proxy, "ダウンロードに使うプロキシ（HTTP_PROXY等の環境変数より優先）")
+		var opts InstallOptions
+		fs.BoolVar(&opts.OnlyUpgrade, "only-upgrade", false, "インストール済みのパッケージだけを更新する")
+		fs.BoolVar(&opts.RequireChecksums, "require-checksums", false, "チェックサムで検証できないソースがあればインストールしない")
+		durability := fs.String("durability", string(pm.durability), "ファイルをディスクに同期する単位（none, per-package, per-file）")
+		fs.StringVar(&pm.arch, "arch", pm.arch, "インストール先のアーキテクチャ（既定はこのマシン、環境変数FRPM_ARCHでも指定可）")
+		fs.StringVar(&opts.Reason, "reason", "", "インストールの理由として記録する文字列")
+		fs.BoolVar(&opts.DryRun, "dry-run", false, "何も変更せず実行計画を表示")
+		jsonOut := fs.Bool("json", false, "--dry-runの計画をJSONで出力")
+		yes := pm.config.AssumeYes
+		fs.BoolVar(&yes, "yes", yes, "確認せずにインストールする（設定ファイルのassume_yesでも指定可）")
+		fs.BoolVar(&yes, "y", yes, "--yesと同じ")
+		fs.Parse(args[1:])
+
+		if fs.NArg() < 1 {
 			fmt.Fprintln(os.Stderr, "エラー: PKGBUILDのパスを指定してください")
 			os.Exit(1)
 		}
-		if err := pm.Install(os.Args[2]); err != nil {
+		if pm.download.OnError != OnErrorAbort && pm.download.OnError != OnErrorContinue {
+			fmt.Fprintf(os.Stderr, "エラー: 不明なダウンロード失敗時の動作: %s\n", pm.download.OnError)
+			os.Exit(1)
+		}
+		if pm.download.Timeout <= 0 {
+			fmt.Fprintln(os.Stderr, "エラー: --download-timeout には正の期間を指定してください")
+			os.Exit(1)
+		}
+		if pm.download.Proxy != "" {
+			if _, err := parseProxy(pm.download.Proxy); err != nil {
+				fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if pm.durability, err = parseDurability(*durability); err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+		if pm.sandbox.User != "" {
+			pm.sandbox.Enabled = true
+		}
+		if *timeout != "" {
+			pm.sandbox.Timeout, err = parseDuration(*timeout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "エラー: タイムアウトの指定が不正です: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if opts.DryRun || !yes {
+			plan, err := pm.PlanInstallAll(fs.Args(), opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+				os.Exit(1)
+			}
+			if opts.DryRun {
+				if err := plan.Print(os.Stdout, *jsonOut); err != nil {
+					fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+					os.Exit(1)
+				}
+				break
+			}
+			if !pm.confirmPlan(os.Stdin, os.Stdout, plan) {
+				fmt.Fprintln(os.Stderr, "中止しました")
+				os.Exit(1)
+			}
+		}
+		// SIGTERMでは実行中のパッケージを最後まで終えてから止める。SIGINTは従来どおり即座に中断する。
+		// パッケージごとに登録が完結するので、止めた時点でDBとファイルは一貫している
+		drain := make(chan os.Signal, 1)
+		signal.Notify(drain, syscall.SIGTERM)
+		if err := pm.InstallAll(fs.Args(), opts, drain); err != nil {
 			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
 			os.Exit(1)
 		}
 	case "list":
-		if err := pm.ListInstalled(); err != nil {
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		newerThanStr := fs.String("newer-than", "", "指定日時以降（または 7d のような期間内）にインストールされたものだけ表示")
+		var opts ListOptions
+		fs.BoolVar(&opts.Bytes, "bytes", false, "サイズをバイト数で表示")
+		fs.BoolVar(&opts.Wide, "wide", false, "インストール理由とインストール元も表示")
+		fs.StringVar(&opts.Group, "group", "", "指定したグループ（PKGBUILDのgroups）に属するものだけ表示")
+		fs.BoolVar(&opts.JSON, "json", *globalJSON, "結果をJSONで出力")
+		fs.Parse(args[1:])
+
+		if *newerThanStr != "" {
+			opts.NewerThan, err = parseSince(*newerThanStr, pm.clock.Now())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := pm.ListInstalled(os.Stdout, opts); err != nil {
 			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
 			os.Exit(1)
 		}
 	case "info":
-		if len(os.Args) < 3 {
+		fs := flag.NewFlagSet("info", flag.ExitOnError)
+		var opts InfoOptions
+		fs.BoolVar(&opts.Bytes, "bytes", false, "サイズをバイト数で表示")
+		fs.BoolVar(&opts.Deps, "deps", false, "依存関係をツリーで表示")
+		fs.BoolVar(&opts.Files, "files", false, "インストールしたファイルの一覧を表示")
+		fs.Parse(args[1:])
+
+		if fs.NArg() < 1 {
 			fmt.Fprintln(os.Stderr, "エラー: パッケージ名を指定してください")
 			os.Exit(1)
 		}
-		if err := pm.Info(os.Args[2]); err != nil {
+		if err := pm.Info(fs.Arg(0), opts); err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+	case "files":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "エラー: パッケージ名を指定してください")
+			os.Exit(1)
+		}
+		paths, err := pm.ListFiles(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+	case "owns":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "エラー: ファイルのパスを指定してください")
+			os.Exit(1)
+		}
+		owner, err := pm.WhichPackageOwns(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s は %s が所有しています\n", args[1], owner)
+	case "hold":
+		fs := flag.NewFlagSet("hold", flag.ExitOnError)
+		list := fs.Bool("list", false, "保持されているパッケージを表示")
+		fs.Parse(args[1:])
+
+		if *list {
+			held, err := pm.ListHeld()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+				os.Exit(1)
+			}
+			if len(held) == 0 {
+				fmt.Println("保持されているパッケージはありません")
+			}
+			for _, pkg := range held {
+				fmt.Printf("%s %s\n", pkg.Name, pkg.FullVersion())
+			}
+			break
+		}
+		if fs.NArg() < 1 {
+			fmt.Fprintln(os.Stderr, "エラー: パッケージ名か --list を指定してください")
+			os.Exit(1)
+		}
+		if err := pm.Hold(fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+	case "unhold":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "エラー: パッケージ名を指定してください")
+			os.Exit(1)
+		}
+		if err := pm.Unhold(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+	case "deps":
+		fs := flag.NewFlagSet("deps", flag.ExitOnError)
+		reverse := fs.Bool("reverse", false, "このパッケージに依存するパッケージを推移的に表示")
+		flat := fs.Bool("flat", false, "ツリーではなく一覧で表示")
+		fs.Parse(args[1:])
+
+		if fs.NArg() < 1 {
+			fmt.Fprintln(os.Stderr, "エラー: パッケージ名を指定してください")
+			os.Exit(1)
+		}
+		if err := pm.ShowDependencies(fs.Arg(0), *reverse, *flat); err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+	case "graph":
+		root := ""
+		if len(args) >= 2 {
+			root = args[1]
+		}
+		if err := pm.ExportGraph(os.Stdout, root); err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+	case "verify":
+		fs := flag.NewFlagSet("verify", flag.ExitOnError)
+		thorough := fs.Bool("thorough", false, "サイズと更新日時が一致するファイルもハッシュを計算して検証")
+		// サイズと更新日時で判定するのが既定なので、--quick は明示するためだけのもの
+		quick := fs.Bool("quick", false, "サイズと更新日時が一致するファイルはハッシュの計算を省略（既定）")
+		all := fs.Bool("all", false, "全てのインストール済みパッケージを検証して集計を表示（省略時の既定）")
+		fs.Parse(args[1:])
+
+		if *quick && *thorough {
+			fmt.Fprintln(os.Stderr, "エラー: --quick と --thorough は同時に指定できません")
+			os.Exit(1)
+		}
+
+		// パッケージ名を省略したら全て検証する
+		if *all || fs.NArg() < 1 {
+			err = pm.VerifyAll(*thorough)
+		} else {
+			err = pm.Verify(fs.Arg(0), *thorough)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+	case "search":
+		fs := flag.NewFlagSet("search", flag.ExitOnError)
+		var opts SearchOptions
+		fs.Var(&opts.Meta, "meta", "メタデータで絞り込む（KEY=VALUE、複数指定可）")
+		fs.BoolVar(&opts.DescriptionOnly, "description-only", false, "名前は無視して説明だけを検索")
+		fs.BoolVar(&opts.Exact, "exact", false, "名前が完全に一致するものだけを検索")
+		fs.BoolVar(&opts.Regex, "regex", false, "QUERYを正規表現として名前に照合")
+		fs.BoolVar(&opts.RegexDesc, "regex-desc", false, "QUERYを正規表現として名前と説明に照合")
+		fs.BoolVar(&opts.JSON, "json", *globalJSON, "結果をJSONで出力")
+		fs.Parse(args[1:])
+
+		if err := pm.Search(os.Stdout, strings.Join(fs.Args(), " "), opts); err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		from := fs.String("from", "", "一覧を取得するパッケージマネージャー（pacman, dpkg, rpm）")
+		explicit := fs.Bool("explicit", false, "明示的にインストールしたものとして登録")
+		auto := fs.Bool("auto", false, "依存関係としてインストールされたものとして登録")
+		fs.Parse(args[1:])
+
+		reason := ReasonImported
+		switch {
+		case *explicit && *auto:
+			fmt.Fprintln(os.Stderr, "エラー: --explicit と --auto は同時に指定できません")
+			os.Exit(1)
+		case *explicit:
+			reason = ReasonExplicit
+		case *auto:
+			reason = ReasonDependency
+		}
+
+		switch {
+		case *from != "":
+			err = pm.ImportFrom(*from, reason)
+		case fs.NArg() < 1:
+			fmt.Fprintln(os.Stderr, "エラー: 一覧のファイルか --from を指定してください")
+			os.Exit(1)
+		case fs.Arg(0) == "-":
+			err = pm.Import(os.Stdin, "stdin", reason)
+		default:
+			var f *os.File
+			f, err = os.Open(fs.Arg(0))
+			if err == nil {
+				err = pm.Import(f, fs.Arg(0), reason)
+				f.Close()
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+	case "freeze":
+		fs := flag.NewFlagSet("freeze", flag.ExitOnError)
+		untilStr := fs.String("until", "", "凍結の期限（日時、または 2h や 3d のような期間）")
+		fs.Parse(args[1:])
+
+		var until time.Time
+		if *untilStr != "" {
+			until, err = parseUntil(*untilStr, pm.clock.Now())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := pm.Freeze(strings.Join(fs.Args(), " "), until); err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+	case "thaw":
+		if err := pm.Thaw(); err != nil {
+			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
+			os.Exit(1)
+		}
+	case "status":
+		if err := pm.FreezeStatus(); err != nil {
 			fmt.Fprintf(os.Stderr, "エラー: %v\n", err)
 			os.Exit(1)
 		}
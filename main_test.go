@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenReadOnlyRejectsOutdatedSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "packages.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 列を追加する前の形式
+	_, err = db.Exec(`CREATE TABLE packages (
+		name TEXT PRIMARY KEY,
+		version TEXT NOT NULL,
+		release TEXT NOT NULL,
+		arch TEXT NOT NULL,
+		installed INTEGER DEFAULT 0,
+		installed_at TIMESTAMP
+	)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pm, err := OpenReadOnly(dbPath, t.TempDir()); err == nil {
+		pm.Close()
+		t.Fatal("OpenReadOnly succeeded on an outdated database")
+	} else if !strings.Contains(err.Error(), "root") {
+		t.Errorf("error %q does not tell how to upgrade the database", err)
+	}
+}
+
+func TestOpenReadOnlyAcceptsCurrentSchema(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "packages.db")
+	pm, err := NewPackageManager(dbPath, filepath.Join(dir, "build"), filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.Close()
+
+	pm, err = OpenReadOnly(dbPath, filepath.Join(dir, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if err := pm.ListInstalled(io.Discard, ListOptions{}); err != nil {
+		t.Errorf("listing a current database read-only: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 計画での操作の種類
+const (
+	ActionInstall   = "install"   // 未インストールのパッケージを入れる
+	ActionUpgrade   = "upgrade"   // 新しいバージョンで置き換える
+	ActionDowngrade = "downgrade" // 古いバージョンで置き換える
+	ActionReinstall = "reinstall" // 同じバージョンを入れ直す
+	ActionSkip      = "skip"      // 何もしない（--only-upgradeで未インストールなど）
+	ActionBlocked   = "blocked"   // 保留・凍結・依存関係の不足のためインストールできない（Installはエラーになる）
+)
+
+// Plan は変更を伴う操作を --dry-run で実行したときの計画
+type Plan struct {
+	Operations []PlanOperation `json:"operations"`
+}
+
+// PlanOperation はパッケージ1つに対する操作
+type PlanOperation struct {
+	Action              string   `json:"action"`
+	Package             string   `json:"package"`
+	Version             string   `json:"version"`
+	Arch                string   `json:"arch"`
+	Installed           string   `json:"installed_version,omitempty"`
+	InstalledSize       int64    `json:"installed_size,omitempty"` // 置き換えるバージョンのインストールサイズ
+	Downloads           []string `json:"downloads"`
+	DownloadSize        int64    `json:"download_size"`                   // Downloadsの合計。HEADリクエストで求める
+	DownloadSizeUnknown int      `json:"download_size_unknown,omitempty"` // サイズがわからなかったダウンロードの数
+	Depends             []string `json:"depends"`
+	MakeDepends         []string `json:"makedepends"`
+	Phases              []string `json:"phases"`
+	Reason              string   `json:"reason,omitempty"`
+}
+
+// PlanInstall はPKGBUILDをインストールした場合に何が起きるかを、何も変更せずに返す
+func (pm *PackageManager) PlanInstall(pkgbuildPath string, opts InstallOptions) (*PlanOperation, error) {
+	pkg, err := pm.ParsePKGBUILD(pkgbuildPath)
+	if err != nil {
+		return nil, fmt.Errorf("PKGBUILDの解析に失敗: %v", err)
+	}
+
+	op := &PlanOperation{
+		Action:      ActionInstall,
+		Package:     pkg.Name,
+		Version:     pkg.FullVersion(),
+		Arch:        pkg.Arch,
+		Downloads:   downloadURLs(pkg),
+		Depends:     pkg.Depends,
+		MakeDepends: pkg.MakeDepends,
+		Phases:      []string{},
+	}
+	for _, phase := range []struct{ name, cmd string }{
+		{"prepare", pkg.PrepareCmd},
+		{"build", pkg.BuildCmd},
+		{"package", pkg.PackageCmd},
+	} {
+		if phase.cmd != "" {
+			op.Phases = append(op.Phases, phase.name)
+		}
+	}
+
+	installed, ok, err := pm.GetInstalled(pkg.Name)
+	switch {
+	case err != nil:
+		return nil, err
+	case !ok:
+		if opts.OnlyUpgrade {
+			op.Action = ActionSkip
+			op.Reason = "インストールされていません（--only-upgrade）"
+		}
+	default:
+		op.Installed = installed.FullVersion()
+		op.InstalledSize = installed.Size
+		if err := checkHeld(installed); err != nil {
+			op.Action = ActionBlocked
+			op.Reason = err.Error()
+			break
+		}
+		switch CompareVersions(op.Version, op.Installed) {
+		case 1:
+			op.Action = ActionUpgrade
+		case -1:
+			op.Action = ActionDowngrade
+		default:
+			op.Action = ActionReinstall
+		}
+	}
+
+	if len(op.Downloads) > 0 {
+		op.DownloadSize, op.DownloadSizeUnknown = pm.downloadSize(op.Downloads)
+	}
+
+	// installスクリプトの関数はインストール済みかどうかで変わる
+	pre, post := installHooks(pkg, op.Installed != "")
+	for _, h := range []scriptHook{pre, post} {
+		if h.Body != "" {
+			op.Phases = append(op.Phases, h.Name)
+		}
+	}
+
+	if err := pm.checkDependencies(pkg); err != nil {
+		op.Action = ActionBlocked
+		op.Reason = err.Error()
+	}
+
+	if err := pm.checkFrozen(); err != nil {
+		op.Action = ActionBlocked
+		op.Reason = err.Error()
+	}
+
+	return op, nil
+}
+
+// PlanInstallAll はpathsそれぞれのPlanInstallをまとめた計画を返す
+func (pm *PackageManager) PlanInstallAll(paths []string, opts InstallOptions) (*Plan, error) {
+	plan := &Plan{Operations: []PlanOperation{}}
+	for _, path := range paths {
+		op, err := pm.PlanInstall(path, opts)
+		if err != nil {
+			return nil, err
+		}
+		plan.Operations = append(plan.Operations, *op)
+	}
+	return plan, nil
+}
+
+// printPlan はInstallOptions.DryRunのときにInstall・InstallAllの代わりに計画を表示する
+func (pm *PackageManager) printPlan(paths []string, opts InstallOptions) error {
+	plan, err := pm.PlanInstallAll(paths, opts)
+	if err != nil {
+		return err
+	}
+	return plan.Print(os.Stdout, false)
+}
+
+// Print は計画を表示する。asJSONならJSONで出力する
+func (p *Plan) Print(w io.Writer, asJSON bool) error {
+	if asJSON {
+		return writeJSON(w, p)
+	}
+
+	fmt.Fprintln(w, "==> 実行計画（--dry-run、何も変更しません）")
+	for _, op := range p.Operations {
+		fmt.Fprintf(w, "%s %s %s (%s)", op.Action, op.Package, op.Version, op.Arch)
+		if op.Installed != "" {
+			fmt.Fprintf(w, " ← %s（%s）", op.Installed, humanizeBytes(op.InstalledSize))
+		}
+		fmt.Fprintln(w)
+
+		if op.Reason != "" {
+			fmt.Fprintf(w, "  理由: %s\n", op.Reason)
+		}
+		if len(op.Downloads) > 0 {
+			fmt.Fprintf(w, "  ダウンロード: %s（合計 %s", strings.Join(op.Downloads, ", "), humanizeBytes(op.DownloadSize))
+			if op.DownloadSizeUnknown > 0 {
+				fmt.Fprintf(w, "、%d 個はサイズ不明", op.DownloadSizeUnknown)
+			}
+			fmt.Fprintln(w, "）")
+		}
+		if len(op.Depends) > 0 {
+			fmt.Fprintf(w, "  依存関係: %s\n", strings.Join(op.Depends, ", "))
+		}
+		if len(op.MakeDepends) > 0 {
+			fmt.Fprintf(w, "  ビルド依存: %s\n", strings.Join(op.MakeDepends, ", "))
+		}
+		if len(op.Phases) > 0 {
+			fmt.Fprintf(w, "  実行する関数: %s\n", strings.Join(op.Phases, ", "))
+		}
+	}
+	return nil
+}
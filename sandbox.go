@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ScriptSandbox はPKGBUILDの関数（prepare/build/package）を実行する際の制限
+type ScriptSandbox struct {
+	Enabled bool
+	User    string        // 空なら現在のユーザーで実行
+	Timeout time.Duration // 0なら無制限
+}
+
+// sandboxPassEnv はサンドボックス内に引き継ぐ環境変数
+var sandboxPassEnv = []string{"PATH", "LANG", "LC_ALL", "TERM", "TZ"}
+
+// env はサンドボックス用に整理した環境変数を返す。HOMEはビルドディレクトリに閉じ込める
+func (sb ScriptSandbox) env(workDir string) []string {
+	if !sb.Enabled {
+		return os.Environ()
+	}
+
+	env := []string{
+		fmt.Sprintf("HOME=%s", workDir),
+		fmt.Sprintf("TMPDIR=%s", workDir),
+	}
+	for _, key := range sandboxPassEnv {
+		if val, ok := os.LookupEnv(key); ok {
+			env = append(env, fmt.Sprintf("%s=%s", key, val))
+		}
+	}
+	return env
+}
+
+// context はタイムアウトが設定されていればそれを反映したcontextを返す
+func (sb ScriptSandbox) context() (context.Context, context.CancelFunc) {
+	if sb.Timeout > 0 {
+		return context.WithTimeout(context.Background(), sb.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// prepare は実行ユーザーの切り替えを設定し、ビルドディレクトリをそのユーザーの所有にする
+func (sb ScriptSandbox) prepare(cmd *exec.Cmd, workDir string) error {
+	if !sb.Enabled || sb.User == "" {
+		return nil
+	}
+
+	u, err := user.Lookup(sb.User)
+	if err != nil {
+		return fmt.Errorf("サンドボックスユーザーの取得に失敗: %v", err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+
+	err = filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, int(uid), int(gid))
+	})
+	if err != nil {
+		return fmt.Errorf("ビルドディレクトリの所有者変更に失敗（root権限が必要です）: %v", err)
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+	}
+	return nil
+}
+
+// scriptError はスクリプトの実行結果を終了コードやタイムアウトがわかるエラーに変換する
+func (sb ScriptSandbox) scriptError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("タイムアウトしました（%s）", sb.Timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("終了コード %d で失敗しました", exitErr.ExitCode())
+	}
+	return err
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// metaFilters は --meta KEY=VALUE を複数受け取るためのflag.Value
+type metaFilters map[string]string
+
+func (m *metaFilters) String() string {
+	pairs := []string{}
+	for k, v := range *m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m *metaFilters) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("KEY=VALUEの形式で指定してください: %s", s)
+	}
+	if *m == nil {
+		*m = metaFilters{}
+	}
+	(*m)[key] = value
+	return nil
+}
+
+// SearchOptions はSearchの絞り込みオプション
+type SearchOptions struct {
+	Meta            metaFilters // メタデータの条件（全て満たすものだけ）
+	DescriptionOnly bool        // 名前は無視して説明だけを検索する
+	Exact           bool        // 名前は部分一致ではなく完全一致で比較する
+	Regex           bool        // 検索語全体を正規表現として名前に照合する
+	RegexDesc       bool        // Regexと同じだが、説明にも照合する
+	JSON            bool        // 結果をSearchResultの配列としてJSONで出力する
+}
+
+// searchTerm は検索語1つ。"name:nginx" や "desc:proxy" のように対象を限定できる
+type searchTerm struct {
+	Field string // searchFieldName、searchFieldDesc、または空（名前か説明）
+	Text  string // 小文字に変換済み
+}
+
+const (
+	searchFieldName = "name"
+	searchFieldDesc = "desc"
+)
+
+// parseQuery は空白区切りの検索語を解析する。"description:" は "desc:" の別名
+func parseQuery(query string) []searchTerm {
+	terms := []searchTerm{}
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		field, text, ok := strings.Cut(word, ":")
+		switch {
+		case ok && field == searchFieldName:
+		case ok && (field == searchFieldDesc || field == "description"):
+			field = searchFieldDesc
+		default:
+			field, text = "", word
+		}
+		if text != "" {
+			terms = append(terms, searchTerm{field, text})
+		}
+	}
+	return terms
+}
+
+// 検索結果の順位。小さいほど上に表示する
+const (
+	rankExactName   = iota // 名前が検索語と一致
+	rankNamePrefix         // 名前が検索語で始まる
+	rankName               // 名前に検索語を含む
+	rankDescription        // 説明にだけ一致
+	rankFuzzy              // 名前に検索語の文字が順に現れる（他に何も一致しない場合のみ）
+)
+
+// matchRank はpkgが全ての検索語に一致するか判定し、一致すれば順位を返す。
+// fuzzyなら名前の部分一致の代わりに文字の出現順で比較する
+func matchRank(pkg *Package, terms []searchTerm, opts SearchOptions, fuzzy bool) (int, bool) {
+	name := strings.ToLower(pkg.Name)
+	desc := strings.ToLower(pkg.Metadata["pkgdesc"])
+
+	rank := rankDescription
+	if fuzzy {
+		rank = rankFuzzy
+	}
+	for _, t := range terms {
+		nameRank, nameMatch := -1, false
+		if t.Field != searchFieldDesc && !(t.Field == "" && opts.DescriptionOnly) {
+			switch {
+			case name == t.Text:
+				nameRank, nameMatch = rankExactName, true
+			case opts.Exact:
+			case fuzzy:
+				nameRank, nameMatch = rankFuzzy, isSubsequence(t.Text, name)
+			case strings.HasPrefix(name, t.Text):
+				nameRank, nameMatch = rankNamePrefix, true
+			case strings.Contains(name, t.Text):
+				nameRank, nameMatch = rankName, true
+			}
+		}
+		// --exact では検索語を名前として扱うので、限定のない語は説明と照合しない
+		descMatch := t.Field != searchFieldName && !(t.Field == "" && opts.Exact) &&
+			strings.Contains(desc, t.Text)
+
+		switch {
+		case nameMatch:
+			rank = min(rank, nameRank)
+		case !descMatch:
+			return 0, false
+		}
+	}
+	return rank, true
+}
+
+// regexpRank はreが名前に一致するか判定する。説明はRegexDescかDescriptionOnlyのときだけ照合する。
+// 大文字と小文字は区別するので、区別しない場合はパターンに(?i)を付ける
+func regexpRank(pkg *Package, re *regexp.Regexp, opts SearchOptions) (int, bool) {
+	if !opts.DescriptionOnly && re.MatchString(pkg.Name) {
+		return rankName, true
+	}
+	if (opts.RegexDesc || opts.DescriptionOnly) && re.MatchString(pkg.Metadata["pkgdesc"]) {
+		return rankDescription, true
+	}
+	return 0, false
+}
+
+// isSubsequence はsubの文字がsの中に同じ順で現れるか判定する
+func isSubsequence(sub, s string) bool {
+	want := []rune(sub)
+	for _, c := range s {
+		if len(want) > 0 && want[0] == c {
+			want = want[1:]
+		}
+	}
+	return len(want) == 0
+}
+
+// SearchResult は search --json で出力する1件分。表形式では省略する項目も含める
+type SearchResult struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Arch        string            `json:"arch"`
+	Size        int64             `json:"size"`
+	InstalledAt time.Time         `json:"installed_at"`
+	Description string            `json:"description"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+// Search はインストール済みパッケージを名前・説明とメタデータで検索して表示する。
+// 検索語は全て一致する必要があり、名前に一致したものを説明だけに一致したものより上に並べる。
+// 何も一致しなければ、名前に検索語の文字が順に現れるものを探す
+func (pm *PackageManager) Search(w io.Writer, query string, opts SearchOptions) error {
+	var re *regexp.Regexp
+	if opts.Regex || opts.RegexDesc {
+		var err error
+		if re, err = regexp.Compile(query); err != nil {
+			return fmt.Errorf("正規表現が不正です: %v", err)
+		}
+	}
+
+	pkgs, err := pm.searchCandidates(opts.Meta)
+	if err != nil {
+		return err
+	}
+
+	var matched []*Package
+	if re != nil {
+		matched = pm.rankPackages(pkgs, func(pkg *Package) (int, bool) {
+			return regexpRank(pkg, re, opts)
+		})
+	} else {
+		terms := parseQuery(query)
+		matched = pm.rankPackages(pkgs, func(pkg *Package) (int, bool) {
+			return matchRank(pkg, terms, opts, false)
+		})
+		if len(matched) == 0 && len(terms) > 0 && !opts.Exact {
+			matched = pm.rankPackages(pkgs, func(pkg *Package) (int, bool) {
+				return matchRank(pkg, terms, opts, true)
+			})
+		}
+	}
+
+	results := []SearchResult{}
+	for _, pkg := range matched {
+		results = append(results, SearchResult{
+			Name:        pkg.Name,
+			Version:     pkg.FullVersion(),
+			Arch:        pkg.Arch,
+			Size:        pkg.Size,
+			InstalledAt: pkg.InstalledAt,
+			Description: pkg.Metadata["pkgdesc"],
+			Metadata:    pkg.Metadata,
+		})
+	}
+
+	if opts.JSON {
+		return writeJSON(w, results)
+	}
+
+	for _, r := range results {
+		fmt.Fprintf(w, "%s %s (%s)\n", r.Name, r.Version, r.Arch)
+		if r.Description != "" {
+			fmt.Fprintf(w, "    %s\n", r.Description)
+		}
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(w, "該当するパッケージはありません")
+	}
+
+	return nil
+}
+
+// searchCandidates はメタデータの条件を全て満たすインストール済みパッケージを名前順で返す
+func (pm *PackageManager) searchCandidates(meta metaFilters) ([]*Package, error) {
+	if len(meta) == 0 {
+		return pm.installedPackages()
+	}
+
+	var result []*Package
+	for key, value := range meta {
+		matched, err := pm.QueryByMetadata(key, value)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = matched
+			continue
+		}
+		// 条件ごとの結果の共通部分を残す。どちらも名前順なので順序は変わらない
+		names := map[string]bool{}
+		for _, pkg := range matched {
+			names[pkg.Name] = true
+		}
+		result = slices.DeleteFunc(result, func(pkg *Package) bool { return !names[pkg.Name] })
+	}
+	return result, nil
+}
+
+// rankPackages はrankで一致するパッケージを順位、名前の順で返す
+func (pm *PackageManager) rankPackages(pkgs []*Package, rank func(*Package) (int, bool)) []*Package {
+	type ranked struct {
+		pkg  *Package
+		rank int
+	}
+	matches := []ranked{}
+	for _, pkg := range pkgs {
+		r, ok := rank(pkg)
+		if !ok {
+			continue
+		}
+		matches = append(matches, ranked{pkg, r})
+	}
+	// pkgsは名前順なので、安定ソートで同じ順位の中は名前順のまま
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].rank < matches[j].rank })
+
+	result := []*Package{}
+	for _, m := range matches {
+		result = append(result, m.pkg)
+	}
+	return result
+}
+
+// QueryByMetadata はメタデータのkeyがvalueに一致するインストール済みパッケージを返す
+func (pm *PackageManager) QueryByMetadata(key, value string) ([]*Package, error) {
+	pkgs, err := pm.installedPackages()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Package{}
+	for _, pkg := range pkgs {
+		if matchesMetadata(pkg, metaFilters{key: value}) {
+			result = append(result, pkg)
+		}
+	}
+	return result, nil
+}
+
+// matchesMetadata は全ての条件を満たすか判定する。
+// license のような配列由来の値は空白区切りの要素のいずれかと一致すればよい
+func matchesMetadata(pkg *Package, meta metaFilters) bool {
+	for key, want := range meta {
+		val, ok := pkg.Metadata[key]
+		if !ok {
+			return false
+		}
+		if val != want && !slices.Contains(strings.Fields(val), want) {
+			return false
+		}
+	}
+	return true
+}
+
+// installedPackages はインストール済みパッケージを名前順に返す。
+// ソース・依存関係・ファイルは読み込まないので、必要ならGetInstalledを使う
+func (pm *PackageManager) installedPackages() ([]*Package, error) {
+	rows, err := pm.db.Query(`
+		SELECT ` + installedColumns + `
+		FROM packages
+		WHERE installed = 1
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pkgs := []*Package{}
+	for rows.Next() {
+		pkg, err := scanInstalled(rows)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	return pkgs, rows.Err()
+}
+
+func parseMetadata(raw sql.NullString) (map[string]string, error) {
+	metadata := map[string]string{}
+	if !raw.Valid || raw.String == "" {
+		return metadata, nil
+	}
+	if err := json.Unmarshal([]byte(raw.String), &metadata); err != nil {
+		return nil, fmt.Errorf("メタデータの解析に失敗: %v", err)
+	}
+	return metadata, nil
+}
+
+var metadataLabels = map[string]string{
+	"pkgdesc": "説明",
+	"url":     "URL",
+	"license": "ライセンス",
+	"groups":  "グループ",
+}
+
+func printMetadata(metadata map[string]string) {
+	keys := []string{}
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	// 既知の変数を先に、それ以外は名前順
+	sort.Slice(keys, func(i, j int) bool {
+		ii, ij := slices.Index(metadataVars, keys[i]), slices.Index(metadataVars, keys[j])
+		if ii == -1 {
+			ii = len(metadataVars)
+		}
+		if ij == -1 {
+			ij = len(metadataVars)
+		}
+		if ii != ij {
+			return ii < ij
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, k := range keys {
+		label := metadataLabels[k]
+		if label == "" {
+			label = k
+		}
+		fmt.Printf("%s: %s\n", label, metadata[k])
+	}
+}
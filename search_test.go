@@ -0,0 +1,27 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRegexpRankMatchesDescriptionOnlyWhenAsked(t *testing.T) {
+	pkg := &Package{Name: "nginx", Metadata: map[string]string{"pkgdesc": "HTTP and reverse proxy server"}}
+	re := regexp.MustCompile("proxy")
+
+	for _, tc := range []struct {
+		opts SearchOptions
+		want bool
+	}{
+		{SearchOptions{Regex: true}, false},
+		{SearchOptions{RegexDesc: true}, true},
+		{SearchOptions{Regex: true, DescriptionOnly: true}, true},
+	} {
+		if _, ok := regexpRank(pkg, re, tc.opts); ok != tc.want {
+			t.Errorf("regexpRank(%+v) matched = %v, want %v", tc.opts, ok, tc.want)
+		}
+	}
+	if rank, ok := regexpRank(pkg, regexp.MustCompile("^ngi"), SearchOptions{Regex: true}); !ok || rank != rankName {
+		t.Errorf("regexpRank on the name = %d, %v; want rankName, true", rank, ok)
+	}
+}
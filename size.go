@@ -0,0 +1,26 @@
+package main
+
+import "fmt"
+
+// humanizeBytes はバイト数を2の累乗の単位（KiB/MiB/GiB…）で表す
+func humanizeBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
+
+// formatSize はrawならバイト数をそのまま、そうでなければhumanizeBytesで整形する
+func formatSize(n int64, raw bool) string {
+	if raw {
+		return fmt.Sprintf("%d", n)
+	}
+	return humanizeBytes(n)
+}
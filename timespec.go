@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 日時の指定として受け付ける書式
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"}
+
+// parseUntil は "2h" や "3d" のような期間（現在からの相対）、または日付・日時を解釈する
+func parseUntil(s string, now time.Time) (time.Time, error) {
+	if d, err := parseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+
+	return parseTime(s)
+}
+
+// parseSince は "7d" のような期間（現在から遡る）、または日付・日時を解釈する
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := parseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return parseTime(s)
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("日時または期間として解釈できません: %s", s)
+}
+
+// parseDuration はtime.ParseDurationに日数（"7d"）の表記を加えたもの
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// formatTime は日時をローカル時刻で表示用に整形する
+func formatTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05")
+}
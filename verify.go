@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// fileProblem は検証で見つかった問題
+type fileProblem struct {
+	Path   string
+	Reason string // "変更" または "欠落"
+}
+
+// Verify はインストール済みファイルを記録と照合する。
+// thoroughでなければサイズと更新日時が記録と一致するファイルはハッシュ計算を省略する
+func (pm *PackageManager) Verify(pkgName string, thorough bool) error {
+	if !pm.isInstalled(pkgName) {
+		return fmt.Errorf("パッケージ %s はインストールされていません", pkgName)
+	}
+
+	files, err := pm.packageFiles(pkgName)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Printf("%s: ファイルの記録がありません（再インストールすると記録されます）\n", pkgName)
+		return nil
+	}
+
+	problems, err := pm.verifyFiles(files, thorough)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: %d 個のファイルを検査しました\n", pkgName, len(files))
+	for _, p := range problems {
+		fmt.Printf("  %s: %s\n", p.Reason, p.Path)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d 個のファイルに問題があります", len(problems))
+	}
+
+	fmt.Println("  問題はありません")
+	return nil
+}
+
+// packageVerification はVerifyAllでのパッケージ1つ分の検査結果
+type packageVerification struct {
+	Name     string
+	Files    []InstalledFile
+	Problems []fileProblem
+	Err      error
+}
+
+// VerifyAll は全てのインストール済みパッケージのファイルを並行して検証し、集計を表示する。
+// 問題のあったパッケージだけ内訳を表示し、1つでも問題があればエラーを返す
+func (pm *PackageManager) VerifyAll(thorough bool) error {
+	pkgs, err := pm.installedPackages()
+	if err != nil {
+		return err
+	}
+
+	results := make([]packageVerification, len(pkgs))
+	for i, pkg := range pkgs {
+		results[i].Name = pkg.Name
+		if results[i].Files, err = pm.packageFiles(pkg.Name); err != nil {
+			return err
+		}
+	}
+
+	// ファイルの検査はDBに触れないので、パッケージ単位でCPU数まで並行に行う
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(runtime.NumCPU(), max(len(results), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i].Problems, results[i].Err = pm.verifyFiles(results[i].Files, thorough)
+			}
+		}()
+	}
+	for i := range results {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	totalFiles, modified, missing, unrecorded, failed := 0, 0, 0, 0, 0
+	for _, r := range results {
+		totalFiles += len(r.Files)
+		if len(r.Files) == 0 {
+			unrecorded++
+		}
+		if r.Err != nil || len(r.Problems) > 0 {
+			failed++
+		}
+		for _, p := range r.Problems {
+			if p.Reason == "欠落" {
+				missing++
+			} else {
+				modified++
+			}
+		}
+	}
+
+	fmt.Printf("%d 個のパッケージ、%d 個のファイルを検査しました\n", len(results), totalFiles)
+	fmt.Printf("変更: %d, 欠落: %d, ファイルの記録なし: %d\n", modified, missing, unrecorded)
+	for _, r := range results {
+		if r.Err == nil && len(r.Problems) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", r.Name)
+		if r.Err != nil {
+			fmt.Printf("  検査に失敗: %v\n", r.Err)
+		}
+		for _, p := range r.Problems {
+			fmt.Printf("  %s: %s\n", p.Reason, p.Path)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d 個のパッケージに問題があります", failed)
+	}
+	fmt.Println("問題はありません")
+	return nil
+}
+
+func (pm *PackageManager) verifyFiles(files []InstalledFile, thorough bool) ([]fileProblem, error) {
+	problems := []fileProblem{}
+	for _, f := range files {
+		info, err := os.Stat(filepath.Join(pm.installRoot, f.Path))
+		if os.IsNotExist(err) {
+			problems = append(problems, fileProblem{f.Path, "欠落"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if info.Size() != f.Size {
+			problems = append(problems, fileProblem{f.Path, "変更"})
+			continue
+		}
+		if !thorough && info.ModTime().UnixNano() == f.ModTime {
+			continue
+		}
+
+		sum, err := hashFile(filepath.Join(pm.installRoot, f.Path))
+		if err != nil {
+			return nil, err
+		}
+		if sum != f.SHA256 {
+			problems = append(problems, fileProblem{f.Path, "変更"})
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CompareVersions は "[epoch:]pkgver[-pkgrel]" 形式のバージョンをpacmanのvercmpと同じ規則で比較し、
+// aがbより古ければ-1、同じなら0、新しければ1を返す。
+// epoch、pkgver、pkgrelの順に比べ、pkgrelは両方にある場合だけ比べる。
+// dpkgの規則には対応していない。dpkgでは~が何よりも前に並ぶ（1.0~rc1 < 1.0）が、
+// ここでは他の記号と同じ区切り文字として扱うので、import --from dpkg で登録した
+// バージョンの比較はdpkgと異なる場合がある
+func CompareVersions(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	epochA, verA, relA := parseEVR(a)
+	epochB, verB, relB := parseEVR(b)
+
+	if ret := rpmvercmp(epochA, epochB); ret != 0 {
+		return ret
+	}
+	if ret := rpmvercmp(verA, verB); ret != 0 {
+		return ret
+	}
+	if relA != "" && relB != "" {
+		return rpmvercmp(relA, relB)
+	}
+	return 0
+}
+
+// parseEVR はバージョン文字列をepoch、pkgver、pkgrelに分ける。epochがなければ"0"
+func parseEVR(s string) (epoch, version, release string) {
+	epoch, version = "0", s
+	digits := strings.IndexFunc(s, func(r rune) bool { return !isDigit(r) })
+	if digits >= 0 && s[digits] == ':' {
+		if digits > 0 {
+			epoch = s[:digits]
+		}
+		version = s[digits+1:]
+	}
+
+	if i := strings.LastIndex(version, "-"); i >= 0 {
+		version, release = version[:i], version[i+1:]
+	}
+	return epoch, version, release
+}
+
+// rpmvercmp はバージョンを数字の並びと英字の並びに区切って順に比較する。
+// 数字同士は数値として、英字同士は辞書順で比べ、数字は英字より新しいとみなす。
+// 末尾に英字が残っている方（1.0rc1と1.0なら1.0rc1）は古い
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	one, two := a, b
+	for one != "" && two != "" {
+		sepOne := len(one) - len(strings.TrimLeftFunc(one, isSeparator))
+		sepTwo := len(two) - len(strings.TrimLeftFunc(two, isSeparator))
+		one, two = one[sepOne:], two[sepTwo:]
+
+		if one == "" || two == "" {
+			break
+		}
+		// 区切り文字の長さが違えば長い方が新しい
+		if sepOne != sepTwo {
+			if sepOne < sepTwo {
+				return -1
+			}
+			return 1
+		}
+
+		isNum := isDigit(rune(one[0]))
+		match := isAlpha
+		if isNum {
+			match = isDigit
+		}
+		segOne, segTwo := leadingRun(one, match), leadingRun(two, match)
+		one, two = one[len(segOne):], two[len(segTwo):]
+
+		// 種類の違う区切り（数字と英字）は数字の方が新しい
+		if segTwo == "" {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		if isNum {
+			segOne = strings.TrimLeft(segOne, "0")
+			segTwo = strings.TrimLeft(segTwo, "0")
+			if len(segOne) != len(segTwo) {
+				if len(segOne) > len(segTwo) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if ret := strings.Compare(segOne, segTwo); ret != 0 {
+			return ret
+		}
+	}
+
+	if one == "" && two == "" {
+		return 0
+	}
+	// 残った英字が空文字列に勝たないようにする
+	if (one == "" && !isAlpha(rune(two[0]))) || (one != "" && isAlpha(rune(one[0]))) {
+		return -1
+	}
+	return 1
+}
+
+// FullVersion はepochを含めた "[epoch:]pkgver-pkgrel" を返す
+func (pkg *Package) FullVersion() string {
+	return formatVersion(pkg.Epoch, pkg.Version, pkg.Release)
+}
+
+// formatVersion はepochが0でなければ付けて "[epoch:]pkgver-pkgrel" にする
+func formatVersion(epoch, version, release string) string {
+	v := version
+	if epoch != "" && epoch != "0" {
+		v = epoch + ":" + v
+	}
+	if release != "" {
+		v += "-" + release
+	}
+	return v
+}
+
+func leadingRun(s string, match func(rune) bool) string {
+	for i, r := range s {
+		if !match(r) {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isAlpha(r rune) bool {
+	return r < unicode.MaxASCII && unicode.IsLetter(r)
+}
+
+func isSeparator(r rune) bool {
+	return !isDigit(r) && !isAlpha(r)
+}
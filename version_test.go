@@ -0,0 +1,96 @@
+package main
+
+import "testing"
+
+// pacmanのtest/util/vercmptest.shのテストベクター。
+// 最後の3つはrpmvercmpの先頭の0の扱いを確かめるもの
+var vercmpTests = []struct {
+	a, b string
+	want int
+}{
+	// 長さが同じでpkgrelなし
+	{"1.5.0", "1.5.0", 0},
+	{"1.5.1", "1.5.0", 1},
+	// 長さが異なる
+	{"1.5.1", "1.5", 1},
+	// pkgrelあり
+	{"1.5.0-1", "1.5.0-1", 0},
+	{"1.5.0-1", "1.5.0-2", -1},
+	{"1.5.0-1", "1.5.1-1", -1},
+	{"1.5.0-2", "1.5.1-1", -1},
+	// pkgrelあり、長さが異なる
+	{"1.5-1", "1.5.1-1", -1},
+	{"1.5-2", "1.5.1-1", -1},
+	{"1.5-2", "1.5.1-2", -1},
+	// pkgrelが片方だけ
+	{"1.5", "1.5-1", 0},
+	{"1.5-1", "1.5", 0},
+	{"1.1-1", "1.1", 0},
+	{"1.0-1", "1.1", -1},
+	{"1.1-1", "1.0", 1},
+	// 英字を含む
+	{"1.5b-1", "1.5-1", -1},
+	{"1.5b", "1.5", -1},
+	{"1.5b-1", "1.5", -1},
+	{"1.5b", "1.5.1", -1},
+	// man pageの例
+	{"1.0a", "1.0alpha", -1},
+	{"1.0alpha", "1.0b", -1},
+	{"1.0b", "1.0beta", -1},
+	{"1.0beta", "1.0rc", -1},
+	{"1.0rc", "1.0", -1},
+	// ドットで区切られた英字
+	{"1.5.a", "1.5", 1},
+	{"1.5.b", "1.5.a", 1},
+	{"1.5.1", "1.5.b", 1},
+	// ドットで区切られた英字とpkgrel
+	{"1.5.b-1", "1.5.b", 0},
+	{"1.5-1", "1.5.b", -1},
+	// 区切り文字だけが異なる
+	{"2.0", "2_0", 0},
+	{"2.0_a", "2_0.a", 0},
+	{"2.0a", "2.0.a", -1},
+	{"2___a", "2_a", 1},
+	// epoch
+	{"0:1.0", "0:1.0", 0},
+	{"0:1.0", "0:1.1", -1},
+	{"1:1.0", "0:1.0", 1},
+	{"1:1.0", "0:1.1", 1},
+	{"1:1.0", "2:1.1", -1},
+	// epochとpkgrel
+	{"1:1.0", "0:1.0-1", 1},
+	{"1:1.0-1", "0:1.1-1", 1},
+	// epochが片方だけ
+	{"0:1.0", "1.0", 0},
+	{"0:1.0", "1.1", -1},
+	{"0:1.1", "1.0", 1},
+	{"1:1.0", "1.0", 1},
+	{"1:1.0", "1.1", 1},
+	{"1:1.1", "1.1", 1},
+	// 先頭の0は無視される
+	{"1.01", "1.1", 0},
+	{"1.001", "1.01", 0},
+	{"1.0010", "1.9", 1},
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tt := range vercmpTests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		// 逆順に比べると結果の符号が反転する
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+// ~ はdpkgと違って区切り文字として扱う（CompareVersionsのコメントを参照）
+func TestCompareVersionsTreatsTildeAsSeparator(t *testing.T) {
+	if got := CompareVersions("1.0~rc1", "1.0"); got != 1 {
+		t.Errorf("CompareVersions(%q, %q) = %d, want 1 (dpkg would give -1)", "1.0~rc1", "1.0", got)
+	}
+	if got := CompareVersions("1.0~rc1", "1.0.rc1"); got != 0 {
+		t.Errorf("CompareVersions(%q, %q) = %d, want 0", "1.0~rc1", "1.0.rc1", got)
+	}
+}